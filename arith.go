@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"math"
+
+	"github.com/shopspring/decimal"
+)
+
+// The int64 operations of the builtin operators, overflows and divisions by
+// zero are handled according to the policies of o
+
+func (o *options) addInt(a, b int64) (value, error) {
+	c := a + b
+	if (a^c)&(b^c) < 0 {
+		return o.overflowed(c, decimal.NewFromInt(a).Add(decimal.NewFromInt(b)))
+	}
+	return intValue(c), nil
+}
+
+func (o *options) subInt(a, b int64) (value, error) {
+	c := a - b
+	if (a^b)&(a^c) < 0 {
+		return o.overflowed(c, decimal.NewFromInt(a).Sub(decimal.NewFromInt(b)))
+	}
+	return intValue(c), nil
+}
+
+func (o *options) mulInt(a, b int64) (value, error) {
+	c := a * b
+	if a != 0 && (c/a != b || a == -1 && b == math.MinInt64) {
+		return o.overflowed(c, decimal.NewFromInt(a).Mul(decimal.NewFromInt(b)))
+	}
+	return intValue(c), nil
+}
+
+func (o *options) divInt(a, b int64) (value, error) {
+	if b == 0 {
+		return o.dividedByZero(false, sign(a), ArgTypeInt)
+	}
+	if a == math.MinInt64 && b == -1 {
+		return o.overflowed(a, decimal.NewFromInt(a).Neg())
+	}
+	return intValue(a / b), nil
+}
+
+func (o *options) modInt(a, b int64) (value, error) {
+	if b == 0 {
+		return o.dividedByZero(true, sign(a), ArgTypeInt)
+	}
+	return intValue(a % b), nil
+}
+
+func (o *options) negInt(a int64) (value, error) {
+	return o.subInt(0, a)
+}
+
+// overflowed applies the overflow policy to an operation whose exact result
+// does not fit in an int64, wrapped is the result Go computes
+func (o *options) overflowed(wrapped int64, exact decimal.Decimal) (value, error) {
+	switch o.overflow {
+	case PolicyNull:
+		return value{}, nil
+	case PolicyWrap:
+		return intValue(wrapped), nil
+	case PolicyPromote:
+		return value{a: exact}, nil
+	case PolicySaturate:
+		if exact.Sign() > 0 {
+			return intValue(math.MaxInt64), nil
+		}
+		return intValue(math.MinInt64), nil
+	}
+	return value{}, ErrOverflow
+}
+
+// dividedByZero applies the division by zero policy to a division, or a
+// modulo, of a dividend of the given sign computed as kind
+func (o *options) dividedByZero(mod bool, sign int, kind ArgType) (value, error) {
+	switch o.divisionZero {
+	case PolicyNull:
+		return value{}, nil
+	case PolicySaturate:
+		if mod {
+			sign = 0
+		}
+		switch kind {
+		case ArgTypeFloat:
+			if sign == 0 {
+				return value{kind: valFloat}, nil
+			}
+			return value{kind: valFloat, f: math.Inf(sign)}, nil
+		case ArgTypeDecimal:
+			return value{a: decimal.NewFromInt(saturated(sign))}, nil
+		}
+		return intValue(saturated(sign)), nil
+	}
+	return value{}, ErrDivisionByZero
+}
+
+// saturated returns the int64 bound of the given sign, 0 for 0
+func saturated(sign int) int64 {
+	switch {
+	case sign > 0:
+		return math.MaxInt64
+	case sign < 0:
+		return math.MinInt64
+	}
+	return 0
+}
+
+func sign(a int64) int {
+	switch {
+	case a > 0:
+		return 1
+	case a < 0:
+		return -1
+	}
+	return 0
+}
+
+// floatArith computes a float operation exactly with decimals then rounds
+// it to the decimal places of o. Infinities and NaN cannot be decimals, they
+// are computed as floats.
+func (o *options) floatArith(a, b float64, dec func(x, y decimal.Decimal) decimal.Decimal, flt func(x, y float64) float64) float64 {
+	if math.IsInf(a, 0) || math.IsNaN(a) || math.IsInf(b, 0) || math.IsNaN(b) {
+		return flt(a, b)
+	}
+	return o.roundFloat(dec(decimal.NewFromFloat(a), decimal.NewFromFloat(b)))
+}
+
+// boxed returns the result of an operation as an interface value
+func boxed(v value, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	return v.box(), nil
+}
+
+func floatAdd(a, b float64) float64 { return a + b }
+func floatSub(a, b float64) float64 { return a - b }
+func floatMul(a, b float64) float64 { return a * b }
+func floatDiv(a, b float64) float64 { return a / b }
+
+func floatSign(f float64) int {
+	switch {
+	case f > 0:
+		return 1
+	case f < 0:
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/go-parser/parser/internal/parser"
+)
+
+// Schema declares the types of the variables an expression may reference.
+// Keys are variable names, or paths such as order.total for nested values.
+// A path below a declared variable or path has type any.
+type Schema map[string]Type
+
+// lookup returns the type of a variable, and false when it is not declared
+func (s Schema) lookup(n *parser.VariableExpr) (Type, bool) {
+	for i := len(n.Path); i >= 0; i-- {
+		ref := (&parser.VariableExpr{Name: n.Name, Path: n.Path[:i]}).Ref()
+		if t, ok := s[ref]; ok {
+			if i < len(n.Path) {
+				return TypeAny, true
+			}
+			return t, true
+		}
+	}
+	return TypeAny, false
+}
+
+// checkedSignatures replace the signatures of the builtins behind operators
+// when expressions are checked against a schema, so that operands are not
+// converted silently
+var checkedSignatures = map[string]*Signature{
+	"add":   {Params: []Param{{"a", TypeNumber}, {"b", TypeNumber}}, Returns: TypeNumber},
+	"sub":   {Params: []Param{{"a", TypeNumber}, {"b", TypeNumber}}, Returns: TypeNumber},
+	"multi": {Params: []Param{{"a", TypeNumber}, {"b", TypeNumber}}, Returns: TypeNumber},
+	"div":   {Params: []Param{{"a", TypeNumber}, {"b", TypeNumber}}, Returns: TypeNumber},
+	"not":   {Params: []Param{{"x", TypeBool}}, Returns: TypeBool},
+	"and":   {Params: []Param{{"a", TypeBool}, {"b", TypeBool}}, Returns: TypeBool},
+	"or":    {Params: []Param{{"a", TypeBool}, {"b", TypeBool}}, Returns: TypeBool},
+	"if":    {Params: []Param{{"cond", TypeBool}, {"then", TypeAny}, {"else", TypeAny}}, Returns: TypeAny},
+}
+
+// signature returns the signature calls to def are checked against, nil if
+// they are not checked
+func (b *builder) signature(name string, def *funcDef) *Signature {
+	if b.opts.schema != nil && def.builtin {
+		if sig, ok := checkedSignatures[name]; ok {
+			return sig
+		}
+	}
+	return def.sig
+}
+
+// checkVariable reports a variable missing from the schema
+func (b *builder) checkVariable(n *parser.VariableExpr) error {
+	if b.opts.schema == nil {
+		return nil
+	}
+	if _, ok := b.opts.schema.lookup(n); !ok {
+		return b.fail(n, n.String(), fmt.Errorf("%w: %s", ErrUndeclaredVariable, n))
+	}
+	return nil
+}
+
+// checkComparison reports == and != between operands of unrelated types
+// when checking against a schema
+func (b *builder) checkComparison(node parser.Node, name string, args []parser.Node) error {
+	if b.opts.schema == nil || (name != "eq" && name != "ne") || len(args) != 2 {
+		return nil
+	}
+	l, r := b.typeOf(args[0]), b.typeOf(args[1])
+	if l == TypeAny || r == TypeAny || l == r || l.numeric() && r.numeric() {
+		return nil
+	}
+	return b.fail(node, b.source(node), fmt.Errorf("%w: cannot compare %s and %s", ErrArgumentType, l, r))
+}
+
+// typeOf infers the type of the value of a node, TypeAny when it is only
+// known at run time
+func (b *builder) typeOf(node parser.Node) Type {
+	var name string
+	switch n := node.(type) {
+	case *parser.LiteralExpr:
+		switch n.Kind {
+		case parser.IntLiteral:
+			return TypeInt
+		case parser.FloatLiteral:
+			return TypeFloat
+		case parser.StringLiteral:
+			return TypeString
+		case parser.BoolLiteral:
+			return TypeBool
+		}
+		return TypeAny
+	case *parser.VariableExpr:
+		if b.opts.schema == nil {
+			return TypeAny
+		}
+		t, _ := b.opts.schema.lookup(n)
+		return t
+	case *parser.CallExpr:
+		name = n.Name
+	case *parser.UnaryExpr:
+		name = n.Func()
+		if t := b.typeOf(n.X); n.Op == parser.Sub && t.numeric() {
+			return t
+		}
+	case *parser.BinaryExpr:
+		name = n.Func()
+		switch n.Op {
+		case parser.Add, parser.Sub, parser.Mul, parser.Div, parser.Mod:
+			if t := arithmeticType(b.typeOf(n.Left), b.typeOf(n.Right)); t != TypeAny {
+				return t
+			}
+		case parser.Coalesce:
+			return commonType(b.typeOf(n.Left), b.typeOf(n.Right))
+		}
+	case *parser.CondExpr:
+		return commonType(b.typeOf(n.Then), b.typeOf(n.Else))
+	default:
+		return TypeAny
+	}
+
+	def, ok := b.funcs[name]
+	if !ok {
+		return TypeAny
+	}
+	if sig := b.signature(name, def); sig != nil {
+		return sig.Returns
+	}
+	return TypeAny
+}
+
+// arithmeticType returns the type of an arithmetic operation on numbers of
+// known types, TypeAny otherwise
+func arithmeticType(l, r Type) Type {
+	switch {
+	case l == TypeInt && r == TypeInt:
+		return TypeInt
+	case l.numeric() && r.numeric() && (l == TypeFloat || r == TypeFloat):
+		return TypeFloat
+	}
+	return TypeAny
+}
+
+// commonType returns the type of a value that is either of type l or of
+// type r, TypeAny when they are unrelated
+func commonType(l, r Type) Type {
+	switch {
+	case l == r:
+		return l
+	case l.numeric() && r.numeric():
+		return TypeNumber
+	}
+	return TypeAny
+}
@@ -0,0 +1,417 @@
+package parser
+
+import (
+	"sync"
+
+	"github.com/spf13/cast"
+
+	"github.com/go-parser/parser/internal/parser"
+)
+
+// valueKind tells which field of a value holds the result
+type valueKind uint8
+
+const (
+	valAny   valueKind = iota // a
+	valInt                    // i, a may hold the original boxed value
+	valFloat                  // f, a may hold the original boxed value
+)
+
+// value is the result of a compiled expression. Numbers computed by the
+// builtin operators stay unboxed so that integer arithmetic does not
+// allocate, they are boxed when passed to a function or returned.
+type value struct {
+	kind valueKind
+	i    int64
+	f    float64
+	a    any
+}
+
+// evalFunc is a compiled expression
+type evalFunc func(vars Resolver) (value, error)
+
+// anyValue wraps a boxed value, numbers of the types the builtins treat as
+// int or float are also kept unboxed
+func anyValue(a any) value {
+	switch v := a.(type) {
+	case int:
+		return value{kind: valInt, i: int64(v), a: a}
+	case int64:
+		return value{kind: valInt, i: v, a: a}
+	case int32:
+		return value{kind: valInt, i: int64(v), a: a}
+	case int16:
+		return value{kind: valInt, i: int64(v), a: a}
+	case int8:
+		return value{kind: valInt, i: int64(v), a: a}
+	case float64:
+		return value{kind: valFloat, f: v, a: a}
+	case float32:
+		return value{kind: valFloat, f: float64(v), a: a}
+	}
+	return value{a: a}
+}
+
+func intValue(i int64) value {
+	return value{kind: valInt, i: i}
+}
+
+func boolValue(b bool) value {
+	return value{a: b}
+}
+
+// box returns v as an interface value
+func (v value) box() any {
+	if v.a != nil {
+		return v.a
+	}
+	switch v.kind {
+	case valInt:
+		return v.i
+	case valFloat:
+		return v.f
+	}
+	return nil
+}
+
+// bool converts v like cast.ToBool
+func (v value) bool() bool {
+	switch {
+	case v.a != nil:
+		return cast.ToBool(v.a)
+	case v.kind == valInt:
+		return v.i != 0
+	case v.kind == valFloat:
+		return v.f != 0
+	}
+	return false
+}
+
+// float returns a numeric v as float64
+func (v value) float() float64 {
+	if v.kind == valInt {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// intOps implement the builtin operators on two int operands, they match
+// the int branch of the builtins in function_list.go
+var intOps = map[string]func(o *options, a, b int64) (value, error){
+	"add":   (*options).addInt,
+	"sub":   (*options).subInt,
+	"multi": (*options).mulInt,
+	"div":   (*options).divInt,
+	"mod":   (*options).modInt,
+	"eq":    func(_ *options, a, b int64) (value, error) { return boolValue(a == b), nil },
+	"ne":    func(_ *options, a, b int64) (value, error) { return boolValue(a != b), nil },
+	"gt":    func(_ *options, a, b int64) (value, error) { return boolValue(a > b), nil },
+	"gte":   func(_ *options, a, b int64) (value, error) { return boolValue(a >= b), nil },
+	"lt":    func(_ *options, a, b int64) (value, error) { return boolValue(a < b), nil },
+	"lte":   func(_ *options, a, b int64) (value, error) { return boolValue(a <= b), nil },
+}
+
+// arithmeticOps are the intOps computing numbers rather than comparing them
+var arithmeticOps = map[string]bool{"add": true, "sub": true, "multi": true, "div": true, "mod": true}
+
+// floatCmps implement the builtin comparisons when an operand is a float
+var floatCmps = map[string]func(a, b float64) bool{
+	"gt":  func(a, b float64) bool { return a > b },
+	"gte": func(a, b float64) bool { return a >= b },
+	"lt":  func(a, b float64) bool { return a < b },
+	"lte": func(a, b float64) bool { return a <= b },
+}
+
+// evaluator returns the compiled form of f. Calls that were not built by
+// ParseExpression are compiled on every run without storing the result,
+// which would race when they run in several goroutines.
+func (f *FunctionCall) evaluator() evalFunc {
+	if f.eval != nil {
+		return f.eval
+	}
+	return compileCall(f, false)
+}
+
+// options returns the options f was parsed with, the defaults for calls
+// built by hand
+func (f *FunctionCall) options() *options {
+	if f.opts == nil {
+		return &defaultOptions
+	}
+	return f.opts
+}
+
+// evalError wraps an error returned by the function of f, naming the
+// failing sub-expression as it is written in the source
+func (f *FunctionCall) evalError(err error) error {
+	expr := f.source
+	if expr == "" {
+		expr = f.Expression
+	}
+	return &EvalError{Expression: expr, FunctionName: f.FunctionName, Err: err}
+}
+
+// compile turns the call tree into closures, storing the result in call.eval
+func compile(call *FunctionCall) evalFunc {
+	call.eval = compileCall(call, true)
+	return call.eval
+}
+
+// compileCall compiles call, store tells whether the compiled form of its
+// sub-calls is stored in them
+func compileCall(call *FunctionCall, store bool) evalFunc {
+	if call.ErrorFunction == nil && call.Function == nil {
+		// If function is nil, it's a variable or constant
+		if call.Variable != "" {
+			return compileVariable(call.opts, call.Variable, call.ref, call.pos)
+		}
+		return compileConst(call.Const)
+	}
+
+	args := make([]evalFunc, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = compileArgument(call, arg, store)
+	}
+
+	switch call.form {
+	case formAnd, formOr:
+		return compileLogical(call.form, args[0], args[1])
+	case formCond:
+		return compileCondition(args[0], args[1], args[2])
+	case formCoalesce:
+		// A missing variable is nil whatever the undefined mode
+		if arg := call.Args[0]; arg.FunctionCall == nil && arg.Variable != "" {
+			lenient := *call.options()
+			lenient.undefined = UndefinedNil
+			args[0] = compileVariable(&lenient, arg.Variable, arg.ref, arg.pos)
+		}
+		return compileCoalesce(args[0], args[1])
+	case formNot:
+		x := args[0]
+		return func(vars Resolver) (value, error) {
+			v, err := x(vars)
+			if err != nil {
+				return value{}, err
+			}
+			return boolValue(!v.bool()), nil
+		}
+	}
+
+	// In decimal mode arithmetic goes through the builtins to stay decimal
+	decimalMode := call.opts != nil && call.opts.decimal
+	if call.def != nil && call.def.builtin && call.FunctionName == "neg" && len(args) == 1 && !decimalMode {
+		return compileNegation(call, args[0])
+	}
+	if call.def != nil && call.def.builtin && len(args) == 2 {
+		if op, ok := intOps[call.FunctionName]; ok && !(decimalMode && arithmeticOps[call.FunctionName]) {
+			return compileOperator(call, op, floatCmps[call.FunctionName], args[0], args[1])
+		}
+	}
+	return compileFunction(call, args)
+}
+
+func compileArgument(call *FunctionCall, arg *FunctionArg, store bool) evalFunc {
+	// If argument is a function call, compile it
+	if arg.FunctionCall != nil {
+		if store {
+			return compile(arg.FunctionCall)
+		}
+		return arg.FunctionCall.evaluator()
+	}
+
+	// If argument is a variable, look it up on every run
+	if arg.Variable != "" {
+		return compileVariable(call.opts, arg.Variable, arg.ref, arg.pos)
+	}
+
+	return compileConst(arg.Const)
+}
+
+func compileConst(c any) evalFunc {
+	v := anyValue(c)
+	return func(Resolver) (value, error) {
+		return v, nil
+	}
+}
+
+func compileVariable(opts *options, name string, ref *parser.VariableExpr, pos int) evalFunc {
+	return func(vars Resolver) (value, error) {
+		val, err := lookupVariable(opts, vars, name, ref, pos)
+		if err != nil {
+			return value{}, err
+		}
+		return anyValue(val), nil
+	}
+}
+
+// compileLogical compiles && and ||, skipping the right operand when the
+// left one already decides the result
+func compileLogical(form callForm, left, right evalFunc) evalFunc {
+	or := form == formOr
+	return func(vars Resolver) (value, error) {
+		l, err := left(vars)
+		if err != nil {
+			return value{}, err
+		}
+		if l.bool() == or {
+			return boolValue(or), nil
+		}
+
+		r, err := right(vars)
+		if err != nil {
+			return value{}, err
+		}
+		return boolValue(r.bool()), nil
+	}
+}
+
+// compileCondition compiles ?:, evaluating only the chosen branch
+func compileCondition(cond, then, otherwise evalFunc) evalFunc {
+	return func(vars Resolver) (value, error) {
+		c, err := cond(vars)
+		if err != nil {
+			return value{}, err
+		}
+		if c.bool() {
+			return then(vars)
+		}
+		return otherwise(vars)
+	}
+}
+
+// compileCoalesce compiles ??, the right operand runs only when the left
+// one is nil
+func compileCoalesce(left, right evalFunc) evalFunc {
+	return func(vars Resolver) (value, error) {
+		l, err := left(vars)
+		if err != nil {
+			return value{}, err
+		}
+		if l.kind != valAny || l.a != nil {
+			return l, nil
+		}
+		return right(vars)
+	}
+}
+
+// compileOperator compiles a builtin binary operator, int operands and
+// float comparisons are computed unboxed, anything else calls the builtin
+func compileOperator(call *FunctionCall, op func(o *options, a, b int64) (value, error), cmp func(a, b float64) bool, left, right evalFunc) evalFunc {
+	fn := call.ErrorFunction
+	opts := call.options()
+	return func(vars Resolver) (value, error) {
+		l, err := left(vars)
+		if err != nil {
+			return value{}, err
+		}
+		r, err := right(vars)
+		if err != nil {
+			return value{}, err
+		}
+
+		var res value
+		switch {
+		case l.kind == valInt && r.kind == valInt:
+			res, err = op(opts, l.i, r.i)
+		case cmp != nil && l.kind != valAny && r.kind != valAny:
+			res = boolValue(cmp(l.float(), r.float()))
+		default:
+			var a any
+			a, err = fn(l.box(), r.box())
+			res = anyValue(a)
+		}
+		if err != nil {
+			return value{}, call.evalError(err)
+		}
+		return res, nil
+	}
+}
+
+// compileNegation compiles the builtin prefix minus, numbers are negated
+// unboxed, anything else calls the builtin
+func compileNegation(call *FunctionCall, x evalFunc) evalFunc {
+	fn := call.ErrorFunction
+	opts := call.options()
+	return func(vars Resolver) (value, error) {
+		v, err := x(vars)
+		if err != nil {
+			return value{}, err
+		}
+		var res any
+		switch v.kind {
+		case valInt:
+			if v, err = opts.negInt(v.i); err == nil {
+				return v, nil
+			}
+		case valFloat:
+			return value{kind: valFloat, f: -v.f}, nil
+		default:
+			res, err = fn(v.box())
+		}
+		if err != nil {
+			return value{}, call.evalError(err)
+		}
+		return anyValue(res), nil
+	}
+}
+
+// compileFunction compiles a call that evaluates every argument and passes
+// them to the function. The argument slice of builtins is reused between
+// runs, other functions may retain theirs and get a new one on every run.
+func compileFunction(call *FunctionCall, args []evalFunc) evalFunc {
+	fn := call.ErrorFunction
+	if fn == nil {
+		f := call.Function
+		fn = func(args ...any) (any, error) {
+			return f(args...), nil
+		}
+	}
+
+	if call.def == nil || !call.def.builtin {
+		return func(vars Resolver) (value, error) {
+			buf := make([]any, len(args))
+			for i, arg := range args {
+				v, err := arg(vars)
+				if err != nil {
+					return value{}, err
+				}
+				buf[i] = v.box()
+			}
+
+			res, err := fn(buf...)
+			if err != nil {
+				return value{}, call.evalError(err)
+			}
+			return anyValue(res), nil
+		}
+	}
+
+	n := len(args)
+	pool := &sync.Pool{
+		New: func() any {
+			buf := make([]any, n)
+			return &buf
+		},
+	}
+	return func(vars Resolver) (value, error) {
+		buf := pool.Get().(*[]any)
+		defer func() {
+			clear(*buf)
+			pool.Put(buf)
+		}()
+
+		for i, arg := range args {
+			v, err := arg(vars)
+			if err != nil {
+				return value{}, err
+			}
+			(*buf)[i] = v.box()
+		}
+
+		res, err := fn(*buf...)
+		if err != nil {
+			return value{}, call.evalError(err)
+		}
+		return anyValue(res), nil
+	}
+}
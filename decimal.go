@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cast"
+)
+
+// round rounds d to places decimal places with mode m
+func (m RoundingMode) round(d decimal.Decimal, places int32) decimal.Decimal {
+	switch m {
+	case RoundHalfEven:
+		return d.RoundBank(places)
+	case RoundFloor:
+		return d.RoundFloor(places)
+	case RoundCeil:
+		return d.RoundCeil(places)
+	case RoundTruncate:
+		return d.RoundDown(places)
+	}
+	return d.Round(places)
+}
+
+// roundFloat rounds a float result to the decimal places of o
+func (o *options) roundFloat(d decimal.Decimal) float64 {
+	return o.rounding.round(d, o.places()).InexactFloat64()
+}
+
+// roundWith implements the builtins rounding x to an optional number of
+// decimal places with mode
+func (o *options) roundWith(name string, mode RoundingMode, args []any) (any, error) {
+	if len(args) == 0 {
+		return nil, errArgumentCount(1, len(args))
+	}
+	places, err := placesArg(name, args)
+	if err != nil {
+		return nil, err
+	}
+	return boxed(o.roundNumber(args[0], o.computeType(args[0], args[0]), func(d decimal.Decimal) decimal.Decimal {
+		return mode.round(d, places)
+	}))
+}
+
+// roundNumber applies round to x and returns the result in the type x is
+// computed in. Infinite and NaN floats are returned as is, integers that no
+// longer fit in an int64 are handled by the overflow policy.
+func (o *options) roundNumber(x any, kind ArgType, round func(d decimal.Decimal) decimal.Decimal) (value, error) {
+	switch kind {
+	case ArgTypeDecimal:
+		return value{a: round(toDecimal(x))}, nil
+	case ArgTypeFloat:
+		f := cast.ToFloat64(x)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return value{kind: valFloat, f: f}, nil
+		}
+		return value{kind: valFloat, f: round(decimal.NewFromFloat(f)).InexactFloat64()}, nil
+	}
+	d := round(decimal.NewFromInt(cast.ToInt64(x)))
+	if i := d.BigInt(); !i.IsInt64() {
+		return o.overflowed(d.IntPart(), d)
+	}
+	return intValue(d.IntPart()), nil
+}
+
+// placesArg returns the optional second argument of the rounding builtins,
+// the number of decimal places to keep. It is 0 when omitted and may be
+// negative to round to tens, hundreds, ...
+func placesArg(name string, args []any) (int32, error) {
+	if len(args) < 2 {
+		return 0, nil
+	}
+	if d, ok := args[1].(decimal.Decimal); ok {
+		// Literals are decimals in decimal mode
+		if !d.IsInteger() {
+			return 0, fmt.Errorf("%w: places of %s: %s is not an integer", ErrArgumentType, name, d)
+		}
+		return int32(d.IntPart()), nil
+	}
+	places, err := cast.ToInt32E(args[1])
+	if err != nil {
+		return 0, fmt.Errorf("%w: places of %s: %v", ErrArgumentType, name, err)
+	}
+	return places, nil
+}
+
+// computeType returns the type two operands are computed in, numbers are
+// computed as decimals in decimal mode
+func (o *options) computeType(a, b any) ArgType {
+	t := getComputeType(a, b)
+	if o.decimal && (t == ArgTypeInt || t == ArgTypeFloat) {
+		return ArgTypeDecimal
+	}
+	return t
+}
+
+// toDecimal converts a number or a numeric string to a decimal, anything
+// else is zero like with cast
+func toDecimal(a any) decimal.Decimal {
+	d, _ := parseDecimal(a)
+	return d
+}
+
+// parseDecimal converts a number or a numeric string to a decimal, ok is
+// false for anything else
+func parseDecimal(a any) (d decimal.Decimal, ok bool) {
+	switch v := a.(type) {
+	case decimal.Decimal:
+		return v, true
+	case *decimal.Decimal:
+		if v != nil {
+			return *v, true
+		}
+	case int, int64, int32, int16, int8:
+		return decimal.NewFromInt(cast.ToInt64(v)), true
+	case float64:
+		return decimal.NewFromFloat(v), true
+	case float32:
+		return decimal.NewFromFloat32(v), true
+	default:
+		if d, err := decimal.NewFromString(cast.ToString(v)); err == nil {
+			return d, true
+		}
+	}
+	return decimal.Zero, false
+}
+
+// compareDecimals compares a and b as decimals, it fails when one of them
+// is not a number rather than comparing it as zero
+func compareDecimals(a, b any) (int, error) {
+	x, ok := parseDecimal(a)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q is not a number", ErrArgumentType, cast.ToString(a))
+	}
+	y, ok := parseDecimal(b)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q is not a number", ErrArgumentType, cast.ToString(b))
+	}
+	return x.Cmp(y), nil
+}
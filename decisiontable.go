@@ -0,0 +1,435 @@
+package parser
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+// ErrMultipleMatches is returned when more than one rule of a decision table
+// with the unique hit policy matches
+var ErrMultipleMatches = errors.New("more than one rule matched")
+
+// HitPolicy selects which matching rules of a DecisionTable make the result
+type HitPolicy string
+
+const (
+	// HitUnique requires at most one rule to match, it is the default
+	HitUnique HitPolicy = "unique"
+	// HitFirst keeps the first matching rule in table order
+	HitFirst HitPolicy = "first"
+	// HitPriority keeps the matching rule with the highest priority, the
+	// first one listed among equals
+	HitPriority HitPolicy = "priority"
+	// HitCollect keeps every matching rule in table order
+	HitCollect HitPolicy = "collect"
+)
+
+// inputVar is the variable a unary test compares the value of its input to
+const inputVar = "_"
+
+// TableRule is a row of a DecisionTable. When holds one unary test per
+// input, Then one expression per output.
+type TableRule struct {
+	When     []string `json:"when"`
+	Then     []string `json:"then"`
+	Priority int      `json:"priority,omitempty"` // Used by the priority hit policy
+	tests    []*FunctionCall
+	outputs  []*FunctionCall
+}
+
+// DecisionTable maps the values of its input expressions to outputs, one
+// rule per row. Cells of the When columns are unary tests applied to the
+// value of their input:
+//
+//	> 100           comparison with <, <=, >, >=, == or !=
+//	"A","B"         any of the listed tests, here equality with a value
+//	[10..20]        range, ( or ] excludes the low bound, ) or [ the high one
+//	not("A","B")    none of the listed tests
+//	-               any value, an empty cell too
+type DecisionTable struct {
+	Name      string      `json:"name,omitempty"`
+	HitPolicy HitPolicy   `json:"hitPolicy,omitempty"`
+	Inputs    []string    `json:"inputs"`  // Expressions
+	Outputs   []string    `json:"outputs"` // Names of the outputs
+	Rules     []TableRule `json:"rules"`
+	inputs    []*FunctionCall
+}
+
+// TableResult is the outcome of evaluating a DecisionTable
+type TableResult struct {
+	Rules   []int            // Indexes of the rules kept, starting at 0
+	Outputs []map[string]any // Outputs of the rules kept, by output name
+}
+
+// LoadDecisionTable decodes a decision table from JSON and parses it using
+// the default Env
+func LoadDecisionTable(data []byte, opts ...Option) (*DecisionTable, error) {
+	var dt DecisionTable
+	if err := json.Unmarshal(data, &dt); err != nil {
+		return nil, err
+	}
+	if err := dt.Parse(opts...); err != nil {
+		return nil, err
+	}
+	return &dt, nil
+}
+
+// LoadDecisionTableCSV reads a decision table from CSV and parses it using
+// the default Env. The header holds the input expressions, then the output
+// names prefixed with =>, and optionally a priority column:
+//
+//	$amount,$tier,=> discount,priority
+//	> 1000,'gold',0.15,2
+//	> 1000,-,0.1,1
+func LoadDecisionTableCSV(r io.Reader, policy HitPolicy, opts ...Option) (*DecisionTable, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("decision table: missing header")
+	}
+
+	dt := &DecisionTable{HitPolicy: policy}
+	priority := -1
+	for i, h := range records[0] {
+		h = strings.TrimSpace(h)
+		switch {
+		case h == "priority":
+			priority = i
+		case strings.HasPrefix(h, "=>"):
+			dt.Outputs = append(dt.Outputs, strings.TrimSpace(h[2:]))
+		case len(dt.Outputs) > 0:
+			return nil, fmt.Errorf("decision table: input %q follows the outputs", h)
+		default:
+			dt.Inputs = append(dt.Inputs, h)
+		}
+	}
+
+	for n, record := range records[1:] {
+		rule := TableRule{}
+		for i, cell := range record {
+			switch {
+			case i == priority:
+				if rule.Priority, err = strconv.Atoi(strings.TrimSpace(cell)); err != nil {
+					return nil, fmt.Errorf("decision table: rule %d: priority: %w", n+1, err)
+				}
+			case len(rule.When) < len(dt.Inputs):
+				rule.When = append(rule.When, cell)
+			default:
+				rule.Then = append(rule.Then, cell)
+			}
+		}
+		dt.Rules = append(dt.Rules, rule)
+	}
+
+	if err := dt.Parse(opts...); err != nil {
+		return nil, err
+	}
+	return dt, nil
+}
+
+// Parse parses the inputs and every cell using the default Env
+func (dt *DecisionTable) Parse(opts ...Option) error {
+	return dt.ParseWith(defaultEnv, opts...)
+}
+
+// ParseWith parses the inputs and every cell using the functions and
+// options of env. All the problems found are returned joined, each naming
+// its rule and column.
+func (dt *DecisionTable) ParseWith(env *Env, opts ...Option) error {
+	switch dt.HitPolicy {
+	case "":
+		dt.HitPolicy = HitUnique
+	case HitUnique, HitFirst, HitPriority, HitCollect:
+	default:
+		return fmt.Errorf("decision table: unknown hit policy %q", dt.HitPolicy)
+	}
+
+	// The table stays unparsed until every cell parses
+	dt.inputs = nil
+	var errs []error
+	inputs := make([]*FunctionCall, len(dt.Inputs))
+	cellOpts := make([][]Option, len(dt.Inputs))
+	for i, input := range dt.Inputs {
+		f, err := env.ParseExpression(input, opts...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("input %d: %w", i+1, err))
+			continue
+		}
+		inputs[i] = f
+		cellOpts[i] = opts
+		// Tests refer to the input as a variable of the schema
+		if schema := f.opts.schema; schema != nil {
+			schema = maps.Clone(schema)
+			schema[inputVar] = f.Type()
+			cellOpts[i] = append(opts[:len(opts):len(opts)], WithSchema(schema))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	tests := make([][]*FunctionCall, len(dt.Rules))
+	outputs := make([][]*FunctionCall, len(dt.Rules))
+	for n := range dt.Rules {
+		rule := &dt.Rules[n]
+		if len(rule.When) != len(dt.Inputs) || len(rule.Then) != len(dt.Outputs) {
+			errs = append(errs, fmt.Errorf("rule %d: got %d tests and %d outputs, want %d and %d",
+				n+1, len(rule.When), len(rule.Then), len(dt.Inputs), len(dt.Outputs)))
+			continue
+		}
+		tests[n] = make([]*FunctionCall, len(rule.When))
+		for i, cell := range rule.When {
+			test, err := unaryTest(cell)
+			if err == nil && test != "" {
+				tests[n][i], err = env.ParseExpression(test, cellOpts[i]...)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("rule %d, input %s: %q: %w", n+1, dt.Inputs[i], cell, err))
+			}
+		}
+		outputs[n] = make([]*FunctionCall, len(rule.Then))
+		for i, cell := range rule.Then {
+			if strings.TrimSpace(cell) == "" {
+				continue
+			}
+			var err error
+			if outputs[n][i], err = env.ParseExpression(cell, opts...); err != nil {
+				errs = append(errs, fmt.Errorf("rule %d, output %s: %w", n+1, dt.Outputs[i], err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	for n := range dt.Rules {
+		dt.Rules[n].tests, dt.Rules[n].outputs = tests[n], outputs[n]
+	}
+	dt.inputs = inputs
+	return nil
+}
+
+// unaryTest converts a cell into a boolean expression on $_, empty when the
+// cell accepts any value
+func unaryTest(cell string) (string, error) {
+	cell = strings.TrimSpace(cell)
+	if cell == "" || cell == "-" {
+		return "", nil
+	}
+	if inner, ok := strings.CutPrefix(cell, "not("); ok && strings.HasSuffix(inner, ")") {
+		test, err := unaryTest(inner[:len(inner)-1])
+		if err != nil || test == "" {
+			return "false", err
+		}
+		return "!(" + test + ")", nil
+	}
+
+	items := splitList(cell)
+	tests := make([]string, len(items))
+	for i, item := range items {
+		test, err := unaryItem(strings.TrimSpace(item))
+		if err != nil {
+			return "", err
+		}
+		tests[i] = "(" + test + ")"
+	}
+	return strings.Join(tests, " || "), nil
+}
+
+// unaryItem converts a range, a comparison or a value into a boolean
+// expression on $_
+func unaryItem(item string) (string, error) {
+	v := "$" + inputVar
+	if item == "" || item == "-" {
+		return "", fmt.Errorf("empty test in list")
+	}
+	if lo, hi, ok := strings.Cut(item, ".."); ok && len(lo) > 0 && len(hi) > 0 && strings.ContainsRune("[](", rune(lo[0])) {
+		lowOp, highOp := " >= ", " <= "
+		if lo[0] != '[' {
+			lowOp = " > "
+		}
+		switch hi[len(hi)-1] {
+		case ']':
+		case ')', '[':
+			highOp = " < "
+		default:
+			return "", fmt.Errorf("range must end with ], ) or [")
+		}
+		lo, hi = strings.TrimSpace(lo[1:]), strings.TrimSpace(hi[:len(hi)-1])
+		return v + lowOp + "(" + lo + ") && " + v + highOp + "(" + hi + ")", nil
+	}
+	for _, op := range []string{"<=", ">=", "!=", "==", "<", ">"} {
+		if rest, ok := strings.CutPrefix(item, op); ok {
+			return v + " " + op + " (" + rest + ")", nil
+		}
+	}
+	return v + " == (" + item + ")", nil
+}
+
+// splitList splits a cell on the commas that are not inside a string,
+// parentheses or brackets
+func splitList(cell string) []string {
+	var (
+		items []string
+		depth int
+		quote byte
+		start int
+	)
+	for i := 0; i < len(cell); i++ {
+		c := cell[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, cell[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, cell[start:])
+}
+
+// Eval evaluates the table with vars. A *NoMatchError is returned when no
+// rule matches, except with the collect hit policy.
+func (dt *DecisionTable) Eval(vars map[string]any) (*TableResult, error) {
+	return dt.EvalWith(MapResolver(vars))
+}
+
+// EvalWith evaluates the table like Eval, fetching variables from r. The
+// inputs are evaluated once.
+func (dt *DecisionTable) EvalWith(r Resolver) (*TableResult, error) {
+	if r == nil {
+		r = MapResolver(nil)
+	}
+	if dt.inputs == nil {
+		return nil, fmt.Errorf("decision table is not parsed")
+	}
+
+	inputs := make([]inputResolver, len(dt.inputs))
+	for i, input := range dt.inputs {
+		val, err := executeFunctionCall(input, r)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %w", dt.Inputs[i], err)
+		}
+		inputs[i] = inputResolver{value: val, next: r}
+	}
+
+	var matched []int
+	for n := range dt.Rules {
+		ok, err := dt.Rules[n].matches(inputs)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", n+1, err)
+		}
+		if !ok {
+			continue
+		}
+		matched = append(matched, n)
+		if dt.HitPolicy == HitFirst {
+			break
+		}
+	}
+
+	if len(matched) == 0 && dt.HitPolicy != HitCollect {
+		return nil, &NoMatchError{Cases: len(dt.Rules)}
+	}
+	switch dt.HitPolicy {
+	case HitUnique:
+		if len(matched) > 1 {
+			return nil, fmt.Errorf("%w: rules %s", ErrMultipleMatches, ruleList(matched))
+		}
+	case HitPriority:
+		best := matched[0]
+		for _, n := range matched[1:] {
+			if dt.Rules[n].Priority > dt.Rules[best].Priority {
+				best = n
+			}
+		}
+		matched = []int{best}
+	}
+
+	res := &TableResult{Rules: matched}
+	for _, n := range matched {
+		out, err := dt.Rules[n].output(dt.Outputs, r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", n+1, err)
+		}
+		res.Outputs = append(res.Outputs, out)
+	}
+	return res, nil
+}
+
+// matches reports whether every test of the rule accepts its input
+func (rule *TableRule) matches(inputs []inputResolver) (bool, error) {
+	for i, test := range rule.tests {
+		if test == nil {
+			continue
+		}
+		ok, err := executeFunctionCall(test, &inputs[i])
+		if err != nil {
+			return false, err
+		}
+		if !cast.ToBool(ok) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// output evaluates the outputs of the rule, an empty cell yields nil
+func (rule *TableRule) output(names []string, r Resolver) (map[string]any, error) {
+	out := make(map[string]any, len(names))
+	for i, f := range rule.outputs {
+		if f == nil {
+			out[names[i]] = nil
+			continue
+		}
+		val, err := executeFunctionCall(f, r)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %w", names[i], err)
+		}
+		out[names[i]] = val
+	}
+	return out, nil
+}
+
+// inputResolver resolves $_ to the value of an input and other variables
+// with next
+type inputResolver struct {
+	value any
+	next  Resolver
+}
+
+func (r *inputResolver) Lookup(name string) (any, bool) {
+	if name == inputVar {
+		return r.value, true
+	}
+	return r.next.Lookup(name)
+}
+
+// ruleList renders rule indexes as the 1-based numbers of the rules
+func ruleList(rules []int) string {
+	numbers := make([]string, len(rules))
+	for i, n := range rules {
+		numbers[i] = strconv.Itoa(n + 1)
+	}
+	return strings.Join(numbers, ", ")
+}
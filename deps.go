@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/go-parser/parser/internal/parser"
+)
+
+// Variables returns the sorted names of the variables the expression
+// references, without the $ prefix. Paths are kept, so $order.customer.tier
+// is reported as order.customer.tier.
+func (f *FunctionCall) Variables() []string {
+	vars := make(map[string]struct{})
+	f.collect(vars, nil)
+	return sortedKeys(vars)
+}
+
+// Functions returns the sorted names of the functions the expression calls
+// with @name(...). The builtins behind operators such as + and && are not
+// included.
+func (f *FunctionCall) Functions() []string {
+	funcs := make(map[string]struct{})
+	f.collect(nil, funcs)
+	return sortedKeys(funcs)
+}
+
+// collect adds the variables and functions referenced by f to the non nil
+// sets. Parsed calls are walked from their syntax tree, which still holds
+// the calls folded into constants.
+func (f *FunctionCall) collect(vars, funcs map[string]struct{}) {
+	if f == nil {
+		return
+	}
+	if f.node != nil {
+		collectNode(f.node, vars, funcs)
+		return
+	}
+
+	// Calls built by hand, all of them are function calls
+	if f.Variable != "" && vars != nil {
+		vars[f.Variable] = struct{}{}
+	}
+	if f.FunctionName != "" && funcs != nil {
+		funcs[f.FunctionName] = struct{}{}
+	}
+	for _, arg := range f.Args {
+		if arg.FunctionCall != nil {
+			arg.FunctionCall.collect(vars, funcs)
+		} else if arg.Variable != "" && vars != nil {
+			vars[arg.Variable] = struct{}{}
+		}
+	}
+}
+
+func collectNode(node parser.Node, vars, funcs map[string]struct{}) {
+	switch n := node.(type) {
+	case *parser.VariableExpr:
+		if vars != nil {
+			vars[n.Ref()] = struct{}{}
+		}
+	case *parser.CallExpr:
+		if funcs != nil {
+			funcs[n.Name] = struct{}{}
+		}
+		for _, arg := range n.Args {
+			collectNode(arg, vars, funcs)
+		}
+	case *parser.UnaryExpr:
+		collectNode(n.X, vars, funcs)
+	case *parser.BinaryExpr:
+		collectNode(n.Left, vars, funcs)
+		collectNode(n.Right, vars, funcs)
+	case *parser.CondExpr:
+		collectNode(n.Cond, vars, funcs)
+		collectNode(n.Then, vars, funcs)
+		collectNode(n.Else, vars, funcs)
+	}
+}
+
+// Variables returns the sorted names of the variables referenced by the
+// condition and both branches. The expression must have been parsed.
+func (e *Expression) Variables() []string {
+	vars := make(map[string]struct{})
+	for _, a := range e.actions() {
+		a.execute.collect(vars, nil)
+	}
+	return sortedKeys(vars)
+}
+
+// Functions returns the sorted names of the functions called by the
+// condition and both branches. The expression must have been parsed.
+func (e *Expression) Functions() []string {
+	funcs := make(map[string]struct{})
+	for _, a := range e.actions() {
+		a.execute.collect(nil, funcs)
+	}
+	return sortedKeys(funcs)
+}
+
+// actions returns the parsed parts of the expression
+func (e *Expression) actions() []*Action {
+	var actions []*Action
+	for _, a := range []*Action{e.ifAction, e.thenAction, e.otherwiseAction} {
+		if a != nil {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	return slices.Sorted(maps.Keys(set))
+}
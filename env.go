@@ -0,0 +1,165 @@
+package parser
+
+import (
+	"sync"
+
+	"github.com/go-parser/parser/internal/parser"
+)
+
+// optionFunction is a builtin that depends on the options an expression is
+// parsed with
+type optionFunction func(o *options, args ...any) (any, error)
+
+// funcDef is a function registered in an Env
+type funcDef struct {
+	fn       ErrorFunction
+	optionFn optionFunction
+	builtin  bool       // Set for the functions of function_list.go
+	pure     bool       // Calls with constant arguments can be folded at parse time
+	sig      *Signature // Checked against calls when set
+}
+
+// bind returns the function to call for an expression parsed with o
+func (d *funcDef) bind(o *options) ErrorFunction {
+	if d.optionFn == nil {
+		return d.fn
+	}
+	return func(args ...any) (any, error) {
+		return d.optionFn(o, args...)
+	}
+}
+
+// Env owns the functions and options expressions are parsed with. Functions
+// registered in one Env are not visible in another. An Env is safe for
+// concurrent use.
+type Env struct {
+	mu    sync.RWMutex
+	funcs map[string]*funcDef
+	opts  options
+}
+
+var defaultEnv = NewEnv(sharedDecimalsPlace)
+
+// DefaultEnv returns the Env used by the package level functions
+func DefaultEnv() *Env {
+	return defaultEnv
+}
+
+// NewEnv returns an Env holding the builtin functions
+func NewEnv(opts ...Option) *Env {
+	env := &Env{
+		funcs: make(map[string]*funcDef, len(funcMap)+len(optionFuncMap)),
+		opts:  *defaultOptions.apply(opts),
+	}
+	for name, f := range funcMap {
+		env.funcs[name] = &funcDef{fn: f, builtin: true, pure: true, sig: funcSignatures[name]}
+	}
+	for name, f := range optionFuncMap {
+		env.funcs[name] = &funcDef{optionFn: f, builtin: true, pure: true, sig: funcSignatures[name]}
+	}
+	return env
+}
+
+// Clone returns a copy of env, functions registered in the copy do not
+// affect env
+func (env *Env) Clone() *Env {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+
+	clone := &Env{
+		funcs: make(map[string]*funcDef, len(env.funcs)),
+		opts:  env.opts,
+	}
+	// A clone of the default Env does not follow SetDecimalsPlace
+	clone.opts.decimalsPlace = env.opts.places()
+	clone.opts.sharedPlaces = nil
+	for name, def := range env.funcs {
+		clone.funcs[name] = def
+	}
+	return clone
+}
+
+// RegisterFunc registers a function in env
+func (env *Env) RegisterFunc(name string, f Function, opts ...FuncOption) {
+	env.RegisterErrorFunc(name, func(args ...any) (any, error) {
+		return f(args...), nil
+	}, opts...)
+}
+
+// RegisterErrorFunc registers a function that can report a failure in env
+func (env *Env) RegisterErrorFunc(name string, f ErrorFunction, opts ...FuncOption) {
+	def := &funcDef{fn: f}
+	for _, opt := range opts {
+		opt(def)
+	}
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.funcs[name] = def
+}
+
+// RegisterGoFunc registers an ordinary Go function in env, such as
+// func(s string, n int) (string, error). Arguments are converted to the
+// parameter types like cast does, a non nil trailing error is returned from
+// the evaluation. The signature checked at parse time is derived from the
+// type of fn.
+func (env *Env) RegisterGoFunc(name string, fn any, opts ...FuncOption) error {
+	f, sig, err := goFunc(name, fn)
+	if err != nil {
+		return err
+	}
+	env.RegisterErrorFunc(name, f, append([]FuncOption{WithSignature(*sig)}, opts...)...)
+	return nil
+}
+
+// SetOptions changes the options of expressions parsed with env afterwards
+func (env *Env) SetOptions(opts ...Option) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.opts = *env.opts.apply(opts)
+}
+
+// SetDecimalsPlace sets the decimal places float results are rounded to
+func (env *Env) SetDecimalsPlace(place int32) {
+	env.SetOptions(WithDecimalsPlace(place))
+}
+
+// SetRounding sets the rounding mode of float results, decimal divisions
+// and the rounding builtins
+func (env *Env) SetRounding(mode RoundingMode) {
+	env.SetOptions(WithRounding(mode))
+}
+
+// ParseExpression parses expr into an executable function call tree using
+// the functions of env. opts override the options of env for this expression.
+func (env *Env) ParseExpression(expr string, opts ...Option) (*FunctionCall, error) {
+	node, err := parser.Parse(expr)
+	if err != nil {
+		return nil, syntaxError(expr, err)
+	}
+
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+
+	b := &builder{
+		input: expr,
+		funcs: env.funcs,
+		opts:  env.opts.apply(opts),
+	}
+	f, err := b.call(node)
+	if err != nil {
+		return nil, err
+	}
+	fold(f)
+	compile(f)
+	return f, nil
+}
+
+// ParseAndExecute parses expr with env and executes it with vars
+func (env *Env) ParseAndExecute(expr string, vars map[string]any, opts ...Option) (any, error) {
+	f, err := env.ParseExpression(expr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return f.ExecuteE(vars)
+}
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-parser/parser/internal/parser"
+)
+
+var (
+	// ErrDivisionByZero is returned by div and mod when the divisor is zero
+	ErrDivisionByZero = errors.New("division by zero")
+	// ErrOverflow is returned when the result of an integer operation does
+	// not fit in an int64
+	ErrOverflow = errors.New("integer overflow")
+	// ErrArgumentCount is returned when a function gets too few arguments
+	ErrArgumentCount = errors.New("wrong number of arguments")
+	// ErrArgumentType is returned when an argument does not match the
+	// signature of a function
+	ErrArgumentType = errors.New("wrong argument type")
+	// ErrUndeclaredVariable is returned when an expression checked against a
+	// Schema references a variable it does not declare
+	ErrUndeclaredVariable = errors.New("undeclared variable")
+	// ErrConditionType is returned when the If of an Expression is not a
+	// boolean
+	ErrConditionType = errors.New("condition is not a boolean")
+)
+
+// ParseError reports an expression that cannot be parsed, with the position
+// of the problem
+type ParseError struct {
+	Input    string   // The expression being parsed
+	Offset   int      // Byte offset of the problem in Input
+	Line     int      // 1-based line of the problem
+	Column   int      // 1-based column of the problem, counted in runes
+	Token    string   // Offending token, empty at the end of the input
+	Expected []string // What would have been accepted instead, if known
+	Msg      string
+	Err      error // Underlying error, if any
+}
+
+func newParseError(input string, offset int, token string, expected []string, msg string) *ParseError {
+	e := &ParseError{
+		Input:    input,
+		Offset:   offset,
+		Line:     1 + strings.Count(input[:offset], "\n"),
+		Token:    token,
+		Expected: expected,
+		Msg:      msg,
+	}
+	lineStart := strings.LastIndexByte(input[:offset], '\n') + 1
+	e.Column = 1 + utf8.RuneCountInString(input[lineStart:offset])
+	return e
+}
+
+// syntaxError converts an error of the internal parser into a *ParseError
+func syntaxError(input string, err error) error {
+	var perr *parser.Error
+	if !errors.As(err, &perr) {
+		return fmt.Errorf("parse %q error: %w", input, err)
+	}
+	return newParseError(input, perr.Pos, perr.Token, perr.Expected, perr.Error())
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse %q error: %d:%d: %s", e.Input, e.Line, e.Column, e.Msg)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Pretty renders the line of the expression holding the problem with a
+// caret under it, followed by the message:
+//
+//	$price > * 2
+//	         ^ expected operand, found "*"
+func (e *ParseError) Pretty() string {
+	lineStart := strings.LastIndexByte(e.Input[:e.Offset], '\n') + 1
+	lineEnd := strings.IndexByte(e.Input[e.Offset:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(e.Input)
+	} else {
+		lineEnd += e.Offset
+	}
+
+	var b strings.Builder
+	if e.Line > 1 || lineEnd < len(e.Input) {
+		fmt.Fprintf(&b, "line %d:\n", e.Line)
+	}
+	b.WriteString(e.Input[lineStart:lineEnd])
+	b.WriteByte('\n')
+	// Keep tabs so the caret lines up with the text above
+	for _, r := range e.Input[lineStart:e.Offset] {
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString("^ ")
+	b.WriteString(e.Msg)
+	return b.String()
+}
+
+// EvalError reports a function that failed while executing an expression.
+// Use errors.Is/errors.As on it to inspect the underlying error.
+type EvalError struct {
+	Expression   string // The failing sub-expression
+	FunctionName string // The function that returned the error
+	Err          error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("execute %q error: %v", e.Expression, e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// UndefinedVariableError is returned in strict mode when a variable is
+// missing from vars, or when its path leads nowhere
+type UndefinedVariableError struct {
+	Name    string // Variable name and path without the $ prefix
+	Missing string // Leading part of the path that cannot be resolved, empty when the variable is missing
+	Pos     int    // Byte offset of the variable in the expression
+}
+
+func (e *UndefinedVariableError) Error() string {
+	if e.Missing != "" {
+		return fmt.Sprintf("undefined variable $%s at position %d: no value at $%s", e.Name, e.Pos, e.Missing)
+	}
+	return fmt.Sprintf("undefined variable $%s at position %d", e.Name, e.Pos)
+}
+
+// NoMatchError is returned when the conditions of all the branches of an
+// Expression or a Switch are false and there is no fallback
+type NoMatchError struct {
+	Cases int // Number of conditions evaluated
+}
+
+func (e *NoMatchError) Error() string {
+	return fmt.Sprintf("no branch matched: %d %s evaluated to false", e.Cases, plural(e.Cases, "condition"))
+}
+
+func errArgumentCount(want, got int) error {
+	return fmt.Errorf("%w: want %d, got %d", ErrArgumentCount, want, got)
+}
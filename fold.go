@@ -0,0 +1,50 @@
+package parser
+
+// fold evaluates the calls of pure functions whose arguments are all
+// constants, bottom up, and replaces them with their result so they are not
+// evaluated again on every run. A call that fails is kept and reports its
+// error when executed.
+func fold(call *FunctionCall) {
+	if call.def == nil {
+		return
+	}
+
+	constant := call.def.pure
+	for _, arg := range call.Args {
+		if arg.FunctionCall != nil {
+			fold(arg.FunctionCall)
+			if arg.FunctionCall.isConst() {
+				arg.Const, arg.FunctionCall = arg.FunctionCall.Const, nil
+			}
+		}
+		if arg.FunctionCall != nil || arg.Variable != "" {
+			constant = false
+		}
+	}
+	if !constant {
+		return
+	}
+
+	args := make([]any, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = arg.Const
+	}
+	val, err := call.ErrorFunction(args...)
+	if err != nil {
+		return
+	}
+	*call = FunctionCall{
+		Expression: call.Expression,
+		Const:      val,
+		pos:        call.pos,
+		source:     call.source,
+		node:       call.node,
+		typ:        call.typ,
+		opts:       call.opts,
+	}
+}
+
+// isConst reports whether f is a constant
+func (f *FunctionCall) isConst() bool {
+	return f.Function == nil && f.ErrorFunction == nil && f.Variable == ""
+}
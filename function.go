@@ -1,216 +1,286 @@
 package parser
 
 import (
-	"errors"
-	"strconv"
+	"fmt"
 	"strings"
-)
 
-const (
-	varPrefix = '$'
-	typeInt   = "int"
-	typeFloat = "float"
-	typeStr   = "str"
+	"github.com/shopspring/decimal"
+
+	"github.com/go-parser/parser/internal/parser"
 )
 
 // Define function type
 type Function func(args ...any) any
 
-func RegisterFunc(name string, f Function) {
-	funcMap[name] = f
+// ErrorFunction is a function that can report a failure, the error is
+// returned from FunctionCall.ExecuteE wrapped in an *EvalError
+type ErrorFunction func(args ...any) (any, error)
+
+// RegisterFunc registers a function in the default Env
+func RegisterFunc(name string, f Function, opts ...FuncOption) {
+	defaultEnv.RegisterFunc(name, f, opts...)
+}
+
+// RegisterErrorFunc registers a function that can report a failure in the
+// default Env
+func RegisterErrorFunc(name string, f ErrorFunction, opts ...FuncOption) {
+	defaultEnv.RegisterErrorFunc(name, f, opts...)
+}
+
+// RegisterGoFunc registers an ordinary Go function in the default Env, see
+// Env.RegisterGoFunc
+func RegisterGoFunc(name string, fn any, opts ...FuncOption) error {
+	return defaultEnv.RegisterGoFunc(name, fn, opts...)
 }
 
 type FunctionArg struct {
 	FunctionCall *FunctionCall
 	Variable     string
 	Const        any
+	pos          int                  // Byte offset of the argument in the source
+	ref          *parser.VariableExpr // Parsed variable, holds its path
 }
 
 // Define function call type
 type FunctionCall struct {
-	Expression   string
-	Function     Function
-	FunctionName string
-	Args         []*FunctionArg // Arguments can be another function call or a constant/variable
-	Variable     string
-	Const        any
+	Expression    string
+	Function      Function
+	ErrorFunction ErrorFunction
+	FunctionName  string
+	Args          []*FunctionArg // Arguments can be another function call or a constant/variable
+	Variable      string         // Variable name, followed by its path if any, e.g. order.items[0]
+	Const         any
+	pos           int                  // Byte offset of the expression in the source
+	source        string               // Source text of the expression, named by evaluation errors
+	ref           *parser.VariableExpr // Parsed variable, holds its path
+	node          parser.Node          // Parsed node the call was built from
+	typ           Type                 // Type of the result inferred at parse time
+	opts          *options             // Options the expression was parsed with
+	form          callForm             // How the arguments are evaluated
+	def           *funcDef             // Registered function the call resolved to
+	eval          evalFunc             // Compiled form of the call
 }
 
-func (f *FunctionCall) Execute(vars map[string]any) any {
-	return executeFunctionCall(f, vars)
-}
+// callForm identifies operators that decide themselves which arguments to
+// evaluate instead of receiving all of them evaluated
+type callForm int
 
-// ParseFunctionExpression parses expression, function call format is funcName(arg1,arg2,...)
-func ParseFunctionExpression(expr string) (*FunctionCall, error) {
-	// Find function name and parameter list
-	left := strings.Index(expr, "(")
-	right := strings.LastIndex(expr, ")")
-	if left == -1 || right == -1 {
-		// If no brackets found, it's a variable or constant
-		fVar := parseVar(expr)
-		return &FunctionCall{
-			Expression: expr,
-			Function:   nil,
-			Const:      fVar.Const,
-			Variable:   fVar.Variable,
-		}, nil
-	}
-	funcName := strings.TrimSpace(expr[:left])
-	paramList := expr[left+1 : right]
+const (
+	formCall     callForm = iota // Evaluate all arguments, then call the function
+	formAnd                      // &&, the right operand runs only if the left is true
+	formOr                       // ||, the right operand runs only if the left is false
+	formNot                      // !
+	formCond                     // ?:, only the chosen branch runs
+	formCoalesce                 // ??, the right operand runs only if the left is nil
+)
 
-	if _, ok := funcMap[funcName]; !ok {
-		return nil, errors.New("function not found: " + funcName)
-	}
+// opForms maps logical operators to their special forms
+var opForms = map[parser.TokenType]callForm{
+	parser.And:      formAnd,
+	parser.Or:       formOr,
+	parser.Not:      formNot,
+	parser.Coalesce: formCoalesce,
+}
 
-	// Create function call
-	call := FunctionCall{
-		Expression:   expr,
-		Function:     funcMap[funcName],
-		FunctionName: funcName,
-	}
-
-	// Parse parameter list
-	level := 0
-	start := 0
-	for i, ch := range paramList {
-		switch ch {
-		case '(':
-			level++
-		case ')':
-			level--
-		case ',':
-			if level == 0 {
-				arg, err := parseArgument(strings.TrimSpace(paramList[start:i]))
-				if err != nil {
-					return nil, err
-				}
-				call.Args = append(call.Args, arg)
-				start = i + 1
-			}
-		}
-	}
+// Execute runs the expression, a failing function yields nil. Use ExecuteE
+// to get the error.
+func (f *FunctionCall) Execute(vars map[string]any) any {
+	val, _ := executeFunctionCall(f, MapResolver(vars))
+	return val
+}
 
-	// Add last argument
-	arg, err := parseArgument(strings.TrimSpace(paramList[start:]))
-	if err != nil {
-		return nil, err
+// ExecuteE runs the expression and returns the first error reported by a
+// function as an *EvalError naming the failing sub-expression
+func (f *FunctionCall) ExecuteE(vars map[string]any) (any, error) {
+	return executeFunctionCall(f, MapResolver(vars))
+}
+
+// ExecuteWith runs the expression like ExecuteE, fetching variables from r
+// as they are evaluated
+func (f *FunctionCall) ExecuteWith(r Resolver) (any, error) {
+	if r == nil {
+		r = MapResolver(nil)
 	}
+	return executeFunctionCall(f, r)
+}
 
-	call.Args = append(call.Args, arg)
+// Type returns the type of the result inferred when the expression was
+// parsed, TypeAny when it is only known at run time
+func (f *FunctionCall) Type() Type {
+	return f.typ
+}
 
-	return &call, nil
+// builder builds function call trees from parsed nodes
+type builder struct {
+	input string              // Source of the expression
+	funcs map[string]*funcDef // Functions calls are resolved against
+	opts  *options            // Options the expression is parsed with
 }
 
-// Parse argument
-func parseArgument(arg string) (*FunctionArg, error) {
-	if strings.Contains(arg, `(`) {
-		f, err := ParseFunctionExpression(arg)
-		if err != nil {
+// call builds the function call tree for a parsed node
+func (b *builder) call(node parser.Node) (*FunctionCall, error) {
+	var (
+		name string
+		args []parser.Node
+		form callForm
+	)
+	switch n := node.(type) {
+	case *parser.LiteralExpr:
+		return &FunctionCall{Expression: n.String(), Const: b.constant(n), pos: n.Loc.Start, node: n, typ: b.typeOf(n), opts: b.opts}, nil
+	case *parser.VariableExpr:
+		if err := b.checkVariable(n); err != nil {
 			return nil, err
 		}
-		return &FunctionArg{
-			FunctionCall: f,
-		}, nil
+		return &FunctionCall{Expression: n.String(), Variable: n.Ref(), pos: n.Loc.Start, ref: n, node: n, typ: b.typeOf(n), opts: b.opts}, nil
+	case *parser.CallExpr:
+		name, args = n.Name, n.Args
+	case *parser.UnaryExpr:
+		name, args, form = n.Func(), []parser.Node{n.X}, opForms[n.Op]
+	case *parser.BinaryExpr:
+		name, args, form = n.Func(), []parser.Node{n.Left, n.Right}, opForms[n.Op]
+	case *parser.CondExpr:
+		name, args, form = n.Func(), []parser.Node{n.Cond, n.Then, n.Else}, formCond
+	default:
+		return nil, fmt.Errorf("unsupported node: %T", node)
 	}
 
-	if arg == "" {
-		return &FunctionArg{Const: arg}, nil
+	def, ok := b.funcs[name]
+	if !ok {
+		return nil, b.errorf(node, name, "function not found: "+name)
 	}
-
-	if arg[0] == varPrefix {
-		return &FunctionArg{Variable: arg[1:]}, nil
+	if sig := b.signature(name, def); sig != nil {
+		if err := b.checkCall(node, name, sig, args); err != nil {
+			return nil, err
+		}
 	}
+	if err := b.checkComparison(node, name, args); err != nil {
+		return nil, err
+	}
+	fn := def.bind(b.opts)
 
-	index := strings.LastIndex(arg, ":")
-
-	typ := arg[index+1:]
-	if index == -1 {
-		typ = typeInt
-	} else {
-		arg = arg[:index]
+	// Create function call
+	call := FunctionCall{
+		Expression: node.String(),
+		Function: func(args ...any) any {
+			val, _ := fn(args...)
+			return val
+		},
+		ErrorFunction: fn,
+		FunctionName:  name,
+		Args:          make([]*FunctionArg, 0, len(args)),
+		pos:           node.Span().Start,
+		source:        b.source(node),
+		node:          node,
+		typ:           b.typeOf(node),
+		opts:          b.opts,
+		form:          form,
+		def:           def,
 	}
 
-	switch typ {
-	case typeInt:
-		val, _ := strconv.ParseInt(arg, 10, 64)
-		return &FunctionArg{Const: val}, nil
-	case typeFloat:
-		val, _ := strconv.ParseFloat(arg, 64)
-		return &FunctionArg{Const: val}, nil
-	case typeStr:
-		return &FunctionArg{Const: arg}, nil
-	default:
-		return nil, errors.New("invalid argument: " + arg)
+	// Build arguments
+	for _, a := range args {
+		arg, err := b.arg(a)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
 	}
+
+	return &call, nil
 }
 
-// Parse variable
-func parseVar(arg string) *FunctionArg {
-	if arg[0] == varPrefix {
-		return &FunctionArg{Variable: arg[1:]}
-	}
+// errorf returns a *ParseError pointing at node
+func (b *builder) errorf(node parser.Node, token string, msg string) error {
+	return newParseError(b.input, node.Span().Start, token, nil, msg)
+}
 
-	if arg == "" {
-		return &FunctionArg{Const: arg}
+// constant returns the value of a literal, numbers are decimals in decimal
+// mode
+func (b *builder) constant(n *parser.LiteralExpr) any {
+	if b.opts.decimal && (n.Kind == parser.IntLiteral || n.Kind == parser.FloatLiteral) {
+		// Raw keeps the spaces between a minus sign and the number
+		if d, err := decimal.NewFromString(strings.Join(strings.Fields(n.Raw), "")); err == nil {
+			return d
+		}
 	}
+	return n.Value
+}
 
-	index := strings.LastIndex(arg, ":")
+// source returns the text of node in the expression
+func (b *builder) source(node parser.Node) string {
+	span := node.Span()
+	return b.input[span.Start:span.End]
+}
+
+// fail returns a *ParseError pointing at node that wraps err
+func (b *builder) fail(node parser.Node, token string, err error) error {
+	perr := newParseError(b.input, node.Span().Start, token, nil, err.Error())
+	perr.Err = err
+	return perr
+}
 
-	typ := arg[index+1:]
-	if index == -1 {
-		typ = typeInt
-	} else {
-		arg = arg[:index]
+// arg builds a function argument for a parsed node
+func (b *builder) arg(node parser.Node) (*FunctionArg, error) {
+	switch n := node.(type) {
+	case *parser.LiteralExpr:
+		return &FunctionArg{Const: b.constant(n), pos: n.Loc.Start}, nil
+	case *parser.VariableExpr:
+		if err := b.checkVariable(n); err != nil {
+			return nil, err
+		}
+		return &FunctionArg{Variable: n.Ref(), pos: n.Loc.Start, ref: n}, nil
 	}
 
-	switch typ {
-	case typeInt:
-		val, _ := strconv.ParseInt(arg, 10, 64)
-		return &FunctionArg{Const: val}
-	case typeFloat:
-		val, _ := strconv.ParseFloat(arg, 64)
-		return &FunctionArg{Const: val}
-	default:
-		return &FunctionArg{Const: arg}
+	f, err := b.call(node)
+	if err != nil {
+		return nil, err
 	}
+	return &FunctionArg{FunctionCall: f, pos: f.pos}, nil
 }
 
 // Execute function call
-func executeFunctionCall(call *FunctionCall, vars map[string]any) any {
-	if call.Function == nil {
-		// If function is nil, it's a variable or constant
-		if call.Variable != "" {
-			if val, ok := vars[call.Variable]; ok {
-				return val
-			}
-			return call.Variable
-		}
-
-		return call.Const
+func executeFunctionCall(call *FunctionCall, r Resolver) (any, error) {
+	v, err := call.evaluator()(r)
+	if err != nil {
+		return nil, err
 	}
+	return v.box(), nil
+}
 
-	// Parse and execute all arguments
-	args := make([]any, len(call.Args))
-	for i, arg := range call.Args {
-		// If argument is a function call, execute it
-		if arg.FunctionCall != nil {
-			args[i] = executeFunctionCall(arg.FunctionCall, vars)
-			continue
-		}
-
-		// If argument is a variable, replace it
-		if arg.Variable != "" {
-			if val, ok := vars[arg.Variable]; ok {
-				args[i] = val
-			} else {
-				args[i] = arg.Variable
-			}
-			continue
+// lookupVariable resolves a variable and its path, a missing one is handled
+// according to the undefined mode the expression was parsed with. Without a
+// parsed reference name is looked up as is.
+func lookupVariable(opts *options, r Resolver, name string, ref *parser.VariableExpr, pos int) (any, error) {
+	if ref == nil || len(ref.Path) == 0 {
+		if val, ok := r.Lookup(name); ok {
+			return val, nil
 		}
+		return undefinedVariable(opts, name, "", pos)
+	}
 
-		args[i] = arg.Const
+	val, ok := r.Lookup(ref.Name)
+	if !ok {
+		return undefinedVariable(opts, name, "", pos)
+	}
+	val, i, ok := resolvePath(val, ref.Path)
+	if !ok {
+		missing := (&parser.VariableExpr{Name: ref.Name, Path: ref.Path[:i+1]}).Ref()
+		return undefinedVariable(opts, name, missing, pos)
 	}
+	return val, nil
+}
 
-	// Execute function
-	return call.Function(args...)
+// undefinedVariable returns what a missing variable evaluates to
+func undefinedVariable(opts *options, name, missing string, pos int) (any, error) {
+	if opts == nil {
+		opts = &defaultOptions
+	}
+	switch opts.undefined {
+	case UndefinedNil:
+		return nil, nil
+	case UndefinedError:
+		return nil, &UndefinedVariableError{Name: name, Missing: missing, Pos: pos}
+	default:
+		return name, nil
+	}
 }
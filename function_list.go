@@ -1,25 +1,51 @@
 package parser
 
 import (
+	"math"
 	"regexp"
 	"strings"
+	"sync/atomic"
 
 	"github.com/shopspring/decimal"
 	"github.com/spf13/cast"
 )
 
-var decimalsPlace int32 = 6
-
 type ArgType int
 
 const (
 	ArgTypeInt ArgType = iota
 	ArgTypeFloat
 	ArgTypeString
+	ArgTypeDecimal
 )
 
+// decimalsPlace holds the decimal places set with SetDecimalsPlace
+var decimalsPlace = func() *atomic.Int32 {
+	p := new(atomic.Int32)
+	p.Store(defaultOptions.decimalsPlace)
+	return p
+}()
+
+// SetDecimalsPlace sets the decimal places float results of the default
+// Env are rounded to. Like the other settings of the default Env it applies
+// to expressions parsed afterwards, and also to those already parsed unless
+// they were given WithDecimalsPlace. Constant sub-expressions are computed
+// when they are parsed, with the decimal places set at that time.
 func SetDecimalsPlace(place int32) {
-	decimalsPlace = place
+	decimalsPlace.Store(place)
+	defaultEnv.SetOptions(sharedDecimalsPlace)
+}
+
+// sharedDecimalsPlace makes expressions read the decimal places set with
+// SetDecimalsPlace when they are executed
+func sharedDecimalsPlace(o *options) {
+	o.sharedPlaces = decimalsPlace
+}
+
+// SetRounding sets the rounding mode of the default Env. It applies to
+// expressions parsed afterwards.
+func SetRounding(mode RoundingMode) {
+	defaultEnv.SetRounding(mode)
 }
 
 func isContainDot(s string) bool {
@@ -32,6 +58,8 @@ func getArgType(a any) ArgType {
 		return ArgTypeInt
 	case float64, float32:
 		return ArgTypeFloat
+	case decimal.Decimal, *decimal.Decimal:
+		return ArgTypeDecimal
 	default:
 		return ArgTypeString
 	}
@@ -41,6 +69,10 @@ func getComputeType(a, b any) ArgType {
 	aType := getArgType(a)
 	bType := getArgType(b)
 
+	if aType == ArgTypeDecimal || bType == ArgTypeDecimal {
+		return ArgTypeDecimal
+	}
+
 	if aType == ArgTypeFloat || bType == ArgTypeFloat {
 		return ArgTypeFloat
 	}
@@ -57,231 +89,377 @@ func getComputeType(a, b any) ArgType {
 }
 
 // 定义函数映射表
-var funcMap = map[string]Function{
-	"append": func(args ...any) any {
+var funcMap = map[string]ErrorFunction{
+	"append": func(args ...any) (any, error) {
 		if len(args) == 0 {
-			return ""
+			return "", nil
 		}
 
 		if len(args) == 1 {
-			return args[0]
+			return args[0], nil
 		}
 
 		a := cast.ToString(args[0])
 		b := cast.ToString(args[1])
-		return a + b
+		return a + b, nil
 	},
-	"trim": func(args ...any) any {
+	"trim": func(args ...any) (any, error) {
 		if len(args) == 0 {
-			return ""
+			return "", nil
 		}
 
 		if len(args) == 1 {
-			return args[0]
+			return args[0], nil
 		}
 		a := cast.ToString(args[0])
 		b := cast.ToString(args[1])
-		return strings.Trim(a, b)
+		return strings.Trim(a, b), nil
 	},
-	"trimInt": func(args ...any) any {
+	"trimInt": func(args ...any) (any, error) {
 		if len(args) == 0 {
-			return 0
+			return 0, nil
 		}
 
 		if len(args) == 1 {
-			return cast.ToInt64(args[0])
+			return cast.ToInt64(args[0]), nil
 		}
 		a := cast.ToString(args[0])
 		b := cast.ToString(args[1])
-		return cast.ToInt64(strings.Trim(a, b))
-	},
-	"add": func(args ...any) any {
-		if len(args) == 0 {
-			return 0
-		}
-
-		if len(args) == 1 {
-			return args[0]
-		}
-
-		computeType := getComputeType(args[0], args[1])
-		if computeType == ArgTypeFloat {
-			dec1 := decimal.NewFromFloat(cast.ToFloat64(args[0]))
-			dec2 := decimal.NewFromFloat(cast.ToFloat64(args[1]))
-			return dec1.Add(dec2).Round(decimalsPlace).InexactFloat64()
-		}
-
-		return cast.ToInt64(args[0]) + cast.ToInt64(args[1])
-	},
-	"sub": func(args ...any) any {
-		if len(args) == 0 {
-			return 0
-		}
-
-		if len(args) == 1 {
-			return args[0]
-		}
-
-		computeType := getComputeType(args[0], args[1])
-		if computeType == ArgTypeFloat {
-			dec1 := decimal.NewFromFloat(cast.ToFloat64(args[0]))
-			dec2 := decimal.NewFromFloat(cast.ToFloat64(args[1]))
-			return dec1.Sub(dec2).Round(decimalsPlace).InexactFloat64()
-		}
-
-		return cast.ToInt64(args[0]) - cast.ToInt64(args[1])
+		return cast.ToInt64(strings.Trim(a, b)), nil
 	},
-
-	"multi": func(args ...any) any {
-		if len(args) == 0 {
-			return 0
-		}
-
-		if len(args) == 1 {
-			return args[0]
-		}
-
-		computeType := getComputeType(args[0], args[1])
-		if computeType == ArgTypeFloat {
-			dec1 := decimal.NewFromFloat(cast.ToFloat64(args[0]))
-			dec2 := decimal.NewFromFloat(cast.ToFloat64(args[1]))
-			return dec1.Mul(dec2).Round(decimalsPlace).InexactFloat64()
-		}
-
-		return cast.ToInt64(args[0]) * cast.ToInt64(args[1])
-	},
-	"div": func(args ...any) any {
-		if len(args) == 0 {
-			return 0
-		}
-
-		if len(args) == 1 {
-			return args[0]
-		}
-
-		computeType := getComputeType(args[0], args[1])
-		if computeType == ArgTypeFloat {
-			dec1 := decimal.NewFromFloat(cast.ToFloat64(args[0]))
-			dec2 := decimal.NewFromFloat(cast.ToFloat64(args[1]))
-			return dec1.Div(dec2).Round(decimalsPlace).InexactFloat64()
-		}
-
-		return cast.ToInt64(args[0]) / cast.ToInt64(args[1])
-	},
-	"mod": func(args ...any) any {
-		if len(args) == 0 {
-			return 0
-		}
-
-		if len(args) == 1 {
-			return args[0]
+	"eq": func(args ...any) (any, error) {
+		if len(args) < 2 {
+			return nil, errArgumentCount(2, len(args))
 		}
-		m := cast.ToInt64(args[1])
-		if m == 0 {
-			return 0
+		if getComputeType(args[0], args[1]) == ArgTypeDecimal {
+			// Operands that are not numbers are compared as strings
+			x, xok := parseDecimal(args[0])
+			y, yok := parseDecimal(args[1])
+			if xok && yok {
+				return x.Equal(y), nil
+			}
 		}
-		return cast.ToInt64(args[0]) % cast.ToInt64(args[1])
+		return cast.ToString(args[0]) == cast.ToString(args[1]), nil
 	},
-	"eq": func(args ...any) any {
+	"ne": func(args ...any) (any, error) {
 		if len(args) < 2 {
-			return false
+			return nil, errArgumentCount(2, len(args))
 		}
-		return cast.ToString(args[0]) == cast.ToString(args[1])
-	},
-	"ne": func(args ...any) any {
-		if len(args) < 2 {
-			return false
+		if getComputeType(args[0], args[1]) == ArgTypeDecimal {
+			// Operands that are not numbers are compared as strings
+			x, xok := parseDecimal(args[0])
+			y, yok := parseDecimal(args[1])
+			if xok && yok {
+				return !x.Equal(y), nil
+			}
 		}
-		return cast.ToString(args[0]) != cast.ToString(args[1])
+		return cast.ToString(args[0]) != cast.ToString(args[1]), nil
 	},
-	"gt": func(args ...any) any {
+	"gt": func(args ...any) (any, error) {
 		if len(args) < 2 {
-			return false
+			return nil, errArgumentCount(2, len(args))
 		}
 		computeType := getComputeType(args[0], args[1])
+		if computeType == ArgTypeDecimal {
+			c, err := compareDecimals(args[0], args[1])
+			return c > 0, err
+		}
 		if computeType == ArgTypeFloat {
-			return cast.ToFloat64(args[0]) > cast.ToFloat64(args[1])
+			return cast.ToFloat64(args[0]) > cast.ToFloat64(args[1]), nil
 		}
-		return cast.ToInt64(args[0]) > cast.ToInt64(args[1])
+		return cast.ToInt64(args[0]) > cast.ToInt64(args[1]), nil
 	},
-	"gte": func(args ...any) any {
+	"gte": func(args ...any) (any, error) {
 		if len(args) < 2 {
-			return false
+			return nil, errArgumentCount(2, len(args))
 		}
 		computeType := getComputeType(args[0], args[1])
+		if computeType == ArgTypeDecimal {
+			c, err := compareDecimals(args[0], args[1])
+			return c >= 0, err
+		}
 		if computeType == ArgTypeFloat {
-			return cast.ToFloat64(args[0]) >= cast.ToFloat64(args[1])
+			return cast.ToFloat64(args[0]) >= cast.ToFloat64(args[1]), nil
 		}
-		return cast.ToInt64(args[0]) >= cast.ToInt64(args[1])
+		return cast.ToInt64(args[0]) >= cast.ToInt64(args[1]), nil
 	},
-	"lt": func(args ...any) any {
+	"lt": func(args ...any) (any, error) {
 		if len(args) < 2 {
-			return false
+			return nil, errArgumentCount(2, len(args))
 		}
 		computeType := getComputeType(args[0], args[1])
+		if computeType == ArgTypeDecimal {
+			c, err := compareDecimals(args[0], args[1])
+			return c < 0, err
+		}
 		if computeType == ArgTypeFloat {
-			return cast.ToFloat64(args[0]) < cast.ToFloat64(args[1])
+			return cast.ToFloat64(args[0]) < cast.ToFloat64(args[1]), nil
 		}
-		return cast.ToInt64(args[0]) < cast.ToInt64(args[1])
+		return cast.ToInt64(args[0]) < cast.ToInt64(args[1]), nil
 	},
-	"lte": func(args ...any) any {
+	"lte": func(args ...any) (any, error) {
 		if len(args) < 2 {
-			return false
+			return nil, errArgumentCount(2, len(args))
 		}
 		computeType := getComputeType(args[0], args[1])
+		if computeType == ArgTypeDecimal {
+			c, err := compareDecimals(args[0], args[1])
+			return c <= 0, err
+		}
 		if computeType == ArgTypeFloat {
-			return cast.ToFloat64(args[0]) <= cast.ToFloat64(args[1])
+			return cast.ToFloat64(args[0]) <= cast.ToFloat64(args[1]), nil
 		}
-		return cast.ToInt64(args[0]) <= cast.ToInt64(args[1])
+		return cast.ToInt64(args[0]) <= cast.ToInt64(args[1]), nil
 	},
-	"hasPrefix": func(args ...any) any {
+	"hasPrefix": func(args ...any) (any, error) {
 		if len(args) < 2 {
-			return false
+			return nil, errArgumentCount(2, len(args))
 		}
 		a := cast.ToString(args[0])
 		b := cast.ToString(args[1])
-		return strings.HasPrefix(a, b)
+		return strings.HasPrefix(a, b), nil
 	},
-	"hasSuffix": func(args ...any) any {
+	"hasSuffix": func(args ...any) (any, error) {
 		if len(args) < 2 {
-			return false
+			return nil, errArgumentCount(2, len(args))
 		}
 		a := cast.ToString(args[0])
 		b := cast.ToString(args[1])
-		return strings.HasSuffix(a, b)
+		return strings.HasSuffix(a, b), nil
 	},
-	"contains": func(args ...any) any {
+	"contains": func(args ...any) (any, error) {
 		if len(args) < 2 {
-			return false
+			return nil, errArgumentCount(2, len(args))
 		}
 		a := cast.ToString(args[0])
 		b := cast.ToString(args[1])
-		return strings.Contains(a, b)
+		return strings.Contains(a, b), nil
 	},
-	"regexp": func(args ...any) any {
+	"regexp": func(args ...any) (any, error) {
 		if len(args) < 2 {
-			return false
+			return nil, errArgumentCount(2, len(args))
 		}
 		a := cast.ToString(args[0])
 		b := cast.ToString(args[1])
-		return regexp.MustCompile(b).MatchString(a)
+		re, err := regexp.Compile(b)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(a), nil
 	},
-	"not": func(args ...any) any {
+	"not": func(args ...any) (any, error) {
 		if len(args) == 0 {
-			return false
+			return nil, errArgumentCount(1, len(args))
+		}
+		return !cast.ToBool(args[0]), nil
+	},
+	"and": func(args ...any) (any, error) {
+		if len(args) < 2 {
+			return nil, errArgumentCount(2, len(args))
+		}
+		return cast.ToBool(args[0]) && cast.ToBool(args[1]), nil
+	},
+	"or": func(args ...any) (any, error) {
+		if len(args) < 2 {
+			return nil, errArgumentCount(2, len(args))
+		}
+		return cast.ToBool(args[0]) || cast.ToBool(args[1]), nil
+	},
+	"if": func(args ...any) (any, error) {
+		if len(args) < 3 {
+			return nil, errArgumentCount(3, len(args))
+		}
+		if cast.ToBool(args[0]) {
+			return args[1], nil
+		}
+		return args[2], nil
+	},
+	"coalesce": func(args ...any) (any, error) {
+		for _, arg := range args {
+			if arg != nil {
+				return arg, nil
+			}
 		}
-		return !cast.ToBool(args[0])
+		return nil, nil
 	},
-	"and": func(args ...any) any {
+}
+
+// optionFuncMap holds the builtins whose result depends on the options an
+// expression is parsed with
+var optionFuncMap = map[string]optionFunction{
+	"add": func(o *options, args ...any) (any, error) {
 		if len(args) == 0 {
-			return false
+			return 0, nil
+		}
+
+		if len(args) == 1 {
+			return args[0], nil
+		}
+
+		switch o.computeType(args[0], args[1]) {
+		case ArgTypeDecimal:
+			return toDecimal(args[0]).Add(toDecimal(args[1])), nil
+		case ArgTypeFloat:
+			return o.floatArith(cast.ToFloat64(args[0]), cast.ToFloat64(args[1]), decimal.Decimal.Add, floatAdd), nil
 		}
-		return cast.ToBool(args[0]) && cast.ToBool(args[1])
+
+		return boxed(o.addInt(cast.ToInt64(args[0]), cast.ToInt64(args[1])))
 	},
-	"or": func(args ...any) any {
+	"sub": func(o *options, args ...any) (any, error) {
 		if len(args) == 0 {
-			return false
+			return 0, nil
+		}
+
+		if len(args) == 1 {
+			return args[0], nil
+		}
+
+		switch o.computeType(args[0], args[1]) {
+		case ArgTypeDecimal:
+			return toDecimal(args[0]).Sub(toDecimal(args[1])), nil
+		case ArgTypeFloat:
+			return o.floatArith(cast.ToFloat64(args[0]), cast.ToFloat64(args[1]), decimal.Decimal.Sub, floatSub), nil
 		}
-		return cast.ToBool(args[0]) || cast.ToBool(args[1])
+
+		return boxed(o.subInt(cast.ToInt64(args[0]), cast.ToInt64(args[1])))
 	},
+	"multi": func(o *options, args ...any) (any, error) {
+		if len(args) == 0 {
+			return 0, nil
+		}
+
+		if len(args) == 1 {
+			return args[0], nil
+		}
+
+		switch o.computeType(args[0], args[1]) {
+		case ArgTypeDecimal:
+			return toDecimal(args[0]).Mul(toDecimal(args[1])), nil
+		case ArgTypeFloat:
+			return o.floatArith(cast.ToFloat64(args[0]), cast.ToFloat64(args[1]), decimal.Decimal.Mul, floatMul), nil
+		}
+
+		return boxed(o.mulInt(cast.ToInt64(args[0]), cast.ToInt64(args[1])))
+	},
+	"neg": func(o *options, args ...any) (any, error) {
+		if len(args) == 0 {
+			return nil, errArgumentCount(1, len(args))
+		}
+		switch o.computeType(args[0], args[0]) {
+		case ArgTypeDecimal:
+			return toDecimal(args[0]).Neg(), nil
+		case ArgTypeFloat:
+			return -cast.ToFloat64(args[0]), nil
+		}
+		return boxed(o.negInt(cast.ToInt64(args[0])))
+	},
+	"div": func(o *options, args ...any) (any, error) {
+		if len(args) == 0 {
+			return 0, nil
+		}
+
+		if len(args) == 1 {
+			return args[0], nil
+		}
+
+		switch o.computeType(args[0], args[1]) {
+		case ArgTypeDecimal:
+			dec1, dec2 := toDecimal(args[0]), toDecimal(args[1])
+			if dec2.IsZero() {
+				return boxed(o.dividedByZero(false, dec1.Sign(), ArgTypeDecimal))
+			}
+			return o.rounding.round(dec1.Div(dec2), o.places()), nil
+		case ArgTypeFloat:
+			f1, f2 := cast.ToFloat64(args[0]), cast.ToFloat64(args[1])
+			if f2 == 0 {
+				return boxed(o.dividedByZero(false, floatSign(f1), ArgTypeFloat))
+			}
+			return o.floatArith(f1, f2, decimal.Decimal.Div, floatDiv), nil
+		}
+
+		return boxed(o.divInt(cast.ToInt64(args[0]), cast.ToInt64(args[1])))
+	},
+	"mod": func(o *options, args ...any) (any, error) {
+		if len(args) == 0 {
+			return 0, nil
+		}
+
+		if len(args) == 1 {
+			return args[0], nil
+		}
+		switch o.computeType(args[0], args[1]) {
+		case ArgTypeDecimal:
+			dec1, dec2 := toDecimal(args[0]), toDecimal(args[1])
+			if dec2.IsZero() {
+				return boxed(o.dividedByZero(true, dec1.Sign(), ArgTypeDecimal))
+			}
+			return dec1.Mod(dec2), nil
+		case ArgTypeFloat:
+			f1, f2 := cast.ToFloat64(args[0]), cast.ToFloat64(args[1])
+			if f2 == 0 {
+				return boxed(o.dividedByZero(true, floatSign(f1), ArgTypeFloat))
+			}
+			return o.floatArith(f1, f2, decimal.Decimal.Mod, math.Mod), nil
+		}
+		return boxed(o.modInt(cast.ToInt64(args[0]), cast.ToInt64(args[1])))
+	},
+	"round": func(o *options, args ...any) (any, error) {
+		return o.roundWith("round", o.rounding, args)
+	},
+	"floor": func(o *options, args ...any) (any, error) {
+		return o.roundWith("floor", RoundFloor, args)
+	},
+	"ceil": func(o *options, args ...any) (any, error) {
+		return o.roundWith("ceil", RoundCeil, args)
+	},
+	"truncate": func(o *options, args ...any) (any, error) {
+		return o.roundWith("truncate", RoundTruncate, args)
+	},
+	"roundTo": func(o *options, args ...any) (any, error) {
+		if len(args) < 2 {
+			return nil, errArgumentCount(2, len(args))
+		}
+		kind := o.computeType(args[0], args[1])
+		step := toDecimal(args[1])
+		if step.IsZero() {
+			return boxed(o.dividedByZero(false, toDecimal(args[0]).Sign(), kind))
+		}
+		return boxed(o.roundNumber(args[0], kind, func(d decimal.Decimal) decimal.Decimal {
+			return o.rounding.round(d.Div(step), 0).Mul(step)
+		}))
+	},
+}
+
+// funcSignatures describes the builtins, calls to them are checked at parse
+// time. The operators accept any operand since they convert it with cast.
+var funcSignatures = map[string]*Signature{
+	"append":    {Params: []Param{{"a", TypeString}, {"b", TypeString}}, Returns: TypeString},
+	"trim":      {Params: []Param{{"s", TypeString}, {"cutset", TypeString}}, Returns: TypeString},
+	"trimInt":   {Params: []Param{{"s", TypeString}, {"cutset", TypeString}}, Returns: TypeInt},
+	"add":       {Params: []Param{{"a", TypeAny}, {"b", TypeAny}}, Returns: TypeNumber},
+	"sub":       {Params: []Param{{"a", TypeAny}, {"b", TypeAny}}, Returns: TypeNumber},
+	"multi":     {Params: []Param{{"a", TypeAny}, {"b", TypeAny}}, Returns: TypeNumber},
+	"div":       {Params: []Param{{"a", TypeAny}, {"b", TypeAny}}, Returns: TypeNumber},
+	"mod":       {Params: []Param{{"a", TypeNumber}, {"b", TypeNumber}}, Returns: TypeNumber},
+	"neg":       {Params: []Param{{"x", TypeNumber}}, Returns: TypeNumber},
+	"eq":        {Params: []Param{{"a", TypeAny}, {"b", TypeAny}}, Returns: TypeBool},
+	"ne":        {Params: []Param{{"a", TypeAny}, {"b", TypeAny}}, Returns: TypeBool},
+	"gt":        {Params: []Param{{"a", TypeNumber}, {"b", TypeNumber}}, Returns: TypeBool},
+	"gte":       {Params: []Param{{"a", TypeNumber}, {"b", TypeNumber}}, Returns: TypeBool},
+	"lt":        {Params: []Param{{"a", TypeNumber}, {"b", TypeNumber}}, Returns: TypeBool},
+	"lte":       {Params: []Param{{"a", TypeNumber}, {"b", TypeNumber}}, Returns: TypeBool},
+	"hasPrefix": {Params: []Param{{"s", TypeString}, {"prefix", TypeString}}, Returns: TypeBool},
+	"hasSuffix": {Params: []Param{{"s", TypeString}, {"suffix", TypeString}}, Returns: TypeBool},
+	"contains":  {Params: []Param{{"s", TypeString}, {"substr", TypeString}}, Returns: TypeBool},
+	"regexp":    {Params: []Param{{"s", TypeString}, {"pattern", TypeString}}, Returns: TypeBool},
+	"round":     {Params: []Param{{"x", TypeNumber}, {"places", TypeInt}}, Optional: 1, Returns: TypeNumber},
+	"floor":     {Params: []Param{{"x", TypeNumber}, {"places", TypeInt}}, Optional: 1, Returns: TypeNumber},
+	"ceil":      {Params: []Param{{"x", TypeNumber}, {"places", TypeInt}}, Optional: 1, Returns: TypeNumber},
+	"truncate":  {Params: []Param{{"x", TypeNumber}, {"places", TypeInt}}, Optional: 1, Returns: TypeNumber},
+	"roundTo":   {Params: []Param{{"x", TypeNumber}, {"step", TypeNumber}}, Returns: TypeNumber},
+	"not":       {Params: []Param{{"x", TypeAny}}, Returns: TypeBool},
+	"and":       {Params: []Param{{"a", TypeAny}, {"b", TypeAny}}, Returns: TypeBool},
+	"or":        {Params: []Param{{"a", TypeAny}, {"b", TypeAny}}, Returns: TypeBool},
+	"if":        {Params: []Param{{"cond", TypeAny}, {"then", TypeAny}, {"else", TypeAny}}, Returns: TypeAny},
+	"coalesce":  {Params: []Param{{"a", TypeAny}, {"b", TypeAny}}, Variadic: true, Returns: TypeAny},
 }
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/spf13/cast"
+)
+
+var errorType = reflect.TypeFor[error]()
+
+// argConverter converts an argument to the type of a parameter
+type argConverter func(arg any) (reflect.Value, error)
+
+// goFunc wraps a typed Go function into an ErrorFunction and derives its
+// signature. fn may return nothing, a value, an error, or a value and an
+// error.
+func goFunc(name string, fn any) (ErrorFunction, *Signature, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, nil, fmt.Errorf("%s: %T is not a function", name, fn)
+	}
+	t := v.Type()
+
+	hasErr := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	results := t.NumOut()
+	if hasErr {
+		results--
+	}
+	if results > 1 {
+		return nil, nil, fmt.Errorf("%s: %s returns more than one value", name, t)
+	}
+
+	sig := &Signature{Variadic: t.IsVariadic(), Returns: TypeAny}
+	if results == 1 {
+		sig.Returns = kindType(t.Out(0))
+	}
+	converters := make([]argConverter, t.NumIn())
+	for i := range t.NumIn() {
+		in := t.In(i)
+		if sig.Variadic && i == t.NumIn()-1 {
+			in = in.Elem()
+		}
+		sig.Params = append(sig.Params, Param{Name: fmt.Sprintf("arg%d", i+1), Type: kindType(in)})
+		converters[i] = converter(in)
+	}
+
+	call := func(args ...any) (any, error) {
+		if len(args) < sig.MinArgs() || !sig.Variadic && len(args) > len(converters) {
+			return nil, fmt.Errorf("%w: %s takes %s, got %d", ErrArgumentCount, name, sig.arity(), len(args))
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			conv := converters[min(i, len(converters)-1)]
+			val, err := conv(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%w: argument %d of %s: %v", ErrArgumentType, i+1, name, err)
+			}
+			in[i] = val
+		}
+
+		out := v.Call(in)
+		if hasErr {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return nil, err
+			}
+		}
+		if results == 0 {
+			return nil, nil
+		}
+		return out[0].Interface(), nil
+	}
+	return call, sig, nil
+}
+
+// kindType returns the signature type of a Go type
+func kindType(t reflect.Type) Type {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return TypeInt
+	case reflect.Float32, reflect.Float64:
+		return TypeFloat
+	case reflect.String:
+		return TypeString
+	case reflect.Bool:
+		return TypeBool
+	}
+	return TypeAny
+}
+
+// converter returns the conversion of arguments to t, scalars are converted
+// with cast, other values must be assignable to t
+func converter(t reflect.Type) argConverter {
+	var conv func(arg any) (any, error)
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		conv = func(arg any) (any, error) { return cast.ToInt64E(arg) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		conv = func(arg any) (any, error) { return cast.ToUint64E(arg) }
+	case reflect.Float32, reflect.Float64:
+		conv = func(arg any) (any, error) { return cast.ToFloat64E(arg) }
+	case reflect.String:
+		conv = func(arg any) (any, error) { return cast.ToStringE(arg) }
+	case reflect.Bool:
+		conv = func(arg any) (any, error) { return cast.ToBoolE(arg) }
+	}
+
+	return func(arg any) (reflect.Value, error) {
+		if conv == nil {
+			if arg == nil {
+				return reflect.Zero(t), nil
+			}
+			val := reflect.ValueOf(arg)
+			if !val.Type().AssignableTo(t) {
+				return reflect.Value{}, fmt.Errorf("cannot use %T as %s", arg, t)
+			}
+			return val, nil
+		}
+
+		c, err := conv(arg)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		val := reflect.New(t).Elem()
+		switch c := c.(type) {
+		case int64:
+			if val.OverflowInt(c) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", c, t)
+			}
+			val.SetInt(c)
+		case uint64:
+			if val.OverflowUint(c) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", c, t)
+			}
+			val.SetUint(c)
+		case float64:
+			val.SetFloat(c)
+		case string:
+			val.SetString(c)
+		case bool:
+			val.SetBool(c)
+		}
+		return val, nil
+	}
+}
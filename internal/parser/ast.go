@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Span is the half-open byte range [Start, End) a node covers in the input
+type Span struct {
+	Start int
+	End   int
+}
+
+// Node is an element of the syntax tree returned by Parse
+type Node interface {
+	// Span returns the source range of the node
+	Span() Span
+	// String renders the node in prefix call form, e.g. add($a,1)
+	String() string
+}
+
+// LiteralKind identifies the type of a literal value
+type LiteralKind int
+
+// Literal kinds
+const (
+	IntLiteral    LiteralKind = iota // int64
+	FloatLiteral                     // float64
+	StringLiteral                    // string
+	BoolLiteral                      // bool, true or false
+	NullLiteral                      // nil, null
+)
+
+// LiteralExpr is a constant written in the input
+type LiteralExpr struct {
+	Kind  LiteralKind
+	Value any    // int64, float64, string, bool or nil depending on Kind
+	Raw   string // Source text of the literal
+	Loc   Span
+}
+
+// VariableExpr is a $name reference, optionally followed by a path into
+// its value such as $order.items[0].price
+type VariableExpr struct {
+	Name string     // Name without the $ prefix
+	Path []PathElem // Elements following the name, if any
+	Loc  Span
+}
+
+// PathElem is a .field or [index] element of a variable path
+type PathElem struct {
+	Key any // string for .field and ["key"], int64 for [n]
+	Loc Span
+}
+
+// CallExpr is an @name(args...) function call
+type CallExpr struct {
+	Name string
+	Args []Node
+	Loc  Span
+}
+
+// UnaryExpr is a prefix operator applied to an operand
+type UnaryExpr struct {
+	Op  TokenType
+	X   Node
+	Loc Span
+}
+
+// BinaryExpr is an infix operator applied to two operands
+type BinaryExpr struct {
+	Op    TokenType
+	Left  Node
+	Right Node
+	Loc   Span
+}
+
+// CondExpr is a cond ? then : else conditional
+type CondExpr struct {
+	Cond Node
+	Then Node
+	Else Node
+	Loc  Span
+}
+
+// unaryFuncs maps prefix operators to the builtin functions implementing them
+var unaryFuncs = map[TokenType]string{
+	Not: "not",
+	Sub: "neg",
+}
+
+// opFuncs maps infix operators to the builtin functions implementing them
+var opFuncs = map[TokenType]string{
+	Add:      "add",
+	Sub:      "sub",
+	Mul:      "multi",
+	Div:      "div",
+	Mod:      "mod",
+	And:      "and",
+	Or:       "or",
+	Gt:       "gt",
+	Gte:      "gte",
+	Lt:       "lt",
+	Lte:      "lte",
+	Eq:       "eq",
+	Ne:       "ne",
+	Coalesce: "coalesce",
+}
+
+func (n *LiteralExpr) Span() Span  { return n.Loc }
+func (n *VariableExpr) Span() Span { return n.Loc }
+func (n *CallExpr) Span() Span     { return n.Loc }
+func (n *UnaryExpr) Span() Span    { return n.Loc }
+func (n *BinaryExpr) Span() Span   { return n.Loc }
+func (n *CondExpr) Span() Span     { return n.Loc }
+
+// Func returns the name of the builtin function implementing the operator
+func (n *UnaryExpr) Func() string { return unaryFuncs[n.Op] }
+
+// Func returns the name of the builtin function implementing the operator
+func (n *BinaryExpr) Func() string { return opFuncs[n.Op] }
+
+// Func returns the name of the builtin function implementing the operator
+func (n *CondExpr) Func() string { return "if" }
+
+func (n *LiteralExpr) String() string {
+	if n.Kind == StringLiteral {
+		return strconv.Quote(n.Value.(string))
+	}
+	return n.Raw
+}
+
+func (n *VariableExpr) String() string {
+	return "$" + n.Ref()
+}
+
+// Ref returns the variable name followed by its path, without the $ prefix
+func (n *VariableExpr) Ref() string {
+	if len(n.Path) == 0 {
+		return n.Name
+	}
+	var b strings.Builder
+	b.WriteString(n.Name)
+	for _, elem := range n.Path {
+		b.WriteString(elem.String())
+	}
+	return b.String()
+}
+
+// String renders the element as .field when the key is an identifier,
+// as [n] or ["key"] otherwise
+func (e PathElem) String() string {
+	switch k := e.Key.(type) {
+	case int64:
+		return "[" + strconv.FormatInt(k, 10) + "]"
+	case string:
+		if isIdentifier(k) {
+			return "." + k
+		}
+		return "[" + strconv.Quote(k) + "]"
+	}
+	return ""
+}
+
+func (n *CallExpr) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return n.Name + "(" + strings.Join(args, ",") + ")"
+}
+
+func (n *UnaryExpr) String() string {
+	return n.Func() + "(" + n.X.String() + ")"
+}
+
+func (n *BinaryExpr) String() string {
+	return n.Func() + "(" + n.Left.String() + "," + n.Right.String() + ")"
+}
+
+func (n *CondExpr) String() string {
+	return n.Func() + "(" + n.Cond.String() + "," + n.Then.String() + "," + n.Else.String() + ")"
+}
+
+// span returns the range covering both nodes
+func span(from, to Node) Span {
+	return Span{Start: from.Span().Start, End: to.Span().End}
+}
@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Error is a syntax error at a position in the input
+type Error struct {
+	Pos      int      // Byte offset of the offending token
+	Token    string   // Offending token, empty at the end of the input
+	Expected []string // What would have been accepted instead
+}
+
+func (e *Error) Error() string {
+	found := "end of input"
+	if e.Token != "" {
+		found = strconv.Quote(e.Token)
+	}
+	if len(e.Expected) == 0 {
+		return "unexpected " + found
+	}
+	return "expected " + joinExpected(e.Expected) + ", found " + found
+}
+
+// joinExpected renders a list as "a", "a or b" or "a, b or c"
+func joinExpected(expected []string) string {
+	if len(expected) == 1 {
+		return expected[0]
+	}
+	last := len(expected) - 1
+	return strings.Join(expected[:last], ", ") + " or " + expected[last]
+}
+
+// Expected token descriptions
+const (
+	expOperand   = "operand"
+	expOperator  = "operator"
+	expFuncName  = "function name"
+	expVarName   = "variable name"
+	expFieldName = "field name"
+	expIndex     = "index"
+)
+
+// errorf returns an error at the current token
+func (p *parser) errorf(expected ...string) error {
+	tok := p.peek(0)
+	return &Error{Pos: tok.Pos, Token: p.input[tok.Pos:tok.End], Expected: expected}
+}
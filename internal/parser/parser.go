@@ -1,14 +1,14 @@
 package parser
 
 import (
-	"errors"
-	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
-// Parse is the main entry point for parsing an input string
-func Parse(input string) (string, error) {
+// Parse is the main entry point for parsing an input string into a syntax tree
+func Parse(input string) (Node, error) {
 	parser := &parser{
 		input: input,
 	}
@@ -20,33 +20,43 @@ type TokenType int
 
 // Token types for operators, literals, and other symbols
 const (
-	Literal    TokenType = iota // String, number literals
-	Add                         // +
-	Sub                         // -
-	Mul                         // *
-	Div                         // /
-	Mod                         // %
-	OpenParen                   // (
-	CloseParen                  // )
-	At                          // @ for function calls
-	Dollar                      // $ for variables
-	Identifier                  // Variable/function names
-	Comma                       // ,
-	And                         // &&
-	Or                          // ||
-	Not                         // !
-	Gt                          // >
-	Gte                         // >=
-	Lt                          // <
-	Lte                         // <=
-	Eq                          // ==
-	Ne                          // !=
+	Literal      TokenType = iota // String, number literals
+	Add                           // +
+	Sub                           // -
+	Mul                           // *
+	Div                           // /
+	Mod                           // %
+	OpenParen                     // (
+	CloseParen                    // )
+	At                            // @ for function calls
+	Dollar                        // $ for variables
+	Identifier                    // Variable/function names
+	Comma                         // ,
+	And                           // &&
+	Or                            // ||
+	Not                           // !
+	Gt                            // >
+	Gte                           // >=
+	Lt                            // <
+	Lte                           // <=
+	Eq                            // ==
+	Ne                            // !=
+	Dot                           // . in variable paths
+	OpenBracket                   // [
+	CloseBracket                  // ]
+	Question                      // ? of the conditional operator
+	Colon                         // : of the conditional operator
+	Coalesce                      // ??
+	EOF                           // End of input
 )
 
 // Token represents a single token with its type and value
 type Token struct {
 	Type  TokenType
-	Value string
+	Value string      // Token text, string literals are unquoted
+	Kind  LiteralKind // Kind of literal, only set for Literal tokens
+	Pos   int         // Byte offset of the token in the input
+	End   int         // Byte offset just past the token
 }
 
 // parser holds the state for parsing expressions
@@ -56,399 +66,501 @@ type parser struct {
 	pos    int     // Current position in tokens
 }
 
+// peek returns the token n positions ahead of the current one, or an EOF
+// token past the end of the input
+func (p *parser) peek(n int) Token {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return Token{Type: EOF, Pos: len(p.input), End: len(p.input)}
+}
+
 // parse tokenizes the input and starts parsing the expression
-func (p *parser) parse() (string, error) {
+func (p *parser) parse() (Node, error) {
 	tokens, err := tokenize(p.input)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	p.tokens = tokens
-	return p.parseLogicalExpression()
+	expr, err := p.expression(lowestPrec)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf(expOperator)
+	}
+	return expr, nil
+}
+
+// Operator precedence, from the loosest to the tightest binding. Binary
+// operators of the same level are left associative, so $a - $b - $c is
+// ($a - $b) - $c and $a < $b == true is ($a < $b) == true. The conditional
+// operator is right associative, $a ? 1 : $b ? 2 : 3 is $a ? 1 : ($b ? 2 : 3).
+//
+//	1  ?:
+//	2  ||
+//	3  &&
+//	4  ==  !=  <  <=  >  >=
+//	5  +  -
+//	6  *  /  %
+//	7  ??
+//	8  !  -  (prefix)
+const (
+	lowestPrec = 1
+	condPrec   = 1
+)
+
+// binaryPrec holds the precedence of the binary operators
+var binaryPrec = map[TokenType]int{
+	Or:       2,
+	And:      3,
+	Eq:       4,
+	Ne:       4,
+	Lt:       4,
+	Lte:      4,
+	Gt:       4,
+	Gte:      4,
+	Add:      5,
+	Sub:      5,
+	Mul:      6,
+	Div:      6,
+	Mod:      6,
+	Coalesce: 7,
 }
 
-// parseLogicalExpression handles logical operators (AND, OR)
-func (p *parser) parseLogicalExpression() (string, error) {
-	left, err := p.parseComparisonExpression()
+// binary builds a binary operator node spanning both operands
+func binary(op TokenType, left, right Node) Node {
+	return &BinaryExpr{Op: op, Left: left, Right: right, Loc: span(left, right)}
+}
+
+// expression parses a sequence of operands joined by binary operators
+// binding at least as tightly as minPrec, by precedence climbing
+func (p *parser) expression(minPrec int) (Node, error) {
+	left, err := p.unary()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	for p.pos < len(p.tokens) {
-		switch p.tokens[p.pos].Type {
-		case And:
-			p.pos++
-			right, err := p.parseComparisonExpression()
-			if err != nil {
-				return "", err
-			}
-			left = fmt.Sprintf("and(%s,%s)", left, right)
-		case Or:
-			p.pos++
-			right, err := p.parseComparisonExpression()
-			if err != nil {
-				return "", err
+	for {
+		op := p.peek(0).Type
+		if op == Question && minPrec <= condPrec {
+			if left, err = p.conditional(left); err != nil {
+				return nil, err
 			}
-			left = fmt.Sprintf("or(%s,%s)", left, right)
-		default:
+			continue
+		}
+		prec, ok := binaryPrec[op]
+		if !ok || prec < minPrec {
 			return left, nil
 		}
+		p.pos++
+		right, err := p.expression(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binary(op, left, right)
 	}
-	return left, nil
 }
 
-// parseComparisonExpression handles comparison operators and NOT operations
-func (p *parser) parseComparisonExpression() (string, error) {
-	if p.pos >= len(p.tokens) {
-		return "", errors.New("unexpected end of input")
+// conditional handles cond ? then : else once cond has been parsed. Any
+// expression may appear between ? and :, the else branch extends as far
+// right as possible.
+func (p *parser) conditional(cond Node) (Node, error) {
+	p.pos++
+	then, err := p.expression(lowestPrec)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(0).Type != Colon {
+		return nil, p.errorf(`":"`)
+	}
+	p.pos++
+	otherwise, err := p.expression(condPrec)
+	if err != nil {
+		return nil, err
 	}
+	return &CondExpr{Cond: cond, Then: then, Else: otherwise, Loc: span(cond, otherwise)}, nil
+}
 
-	if p.tokens[p.pos].Type == Not {
+// unary handles the prefix operators, they bind tighter than any binary
+// operator so !$a == $b is (!$a) == $b
+func (p *parser) unary() (Node, error) {
+	switch p.peek(0).Type {
+	case Not:
+		start := p.peek(0).Pos
 		p.pos++
-		expr, err := p.parseComparisonExpression()
+		x, err := p.unary()
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		return fmt.Sprintf("not(%s)", expr), nil
+		return &UnaryExpr{Op: Not, X: x, Loc: Span{Start: start, End: x.Span().End}}, nil
+	case Sub:
+		return p.negation()
 	}
+	return p.operand()
+}
 
-	if p.tokens[p.pos].Type == OpenParen {
+// operand handles parentheses, function calls, variables, and literals
+func (p *parser) operand() (Node, error) {
+	switch tok := p.peek(0); tok.Type {
+	case OpenParen:
 		p.pos++
-		startPos := p.pos
-		expr, err := p.parseLogicalExpression()
+		expr, err := p.expression(lowestPrec)
 		if err != nil {
-			p.pos = startPos
-			expr, err = p.additive()
-			if err != nil {
-				return "", err
-			}
+			return nil, err
 		}
-
-		if p.pos >= len(p.tokens) || p.tokens[p.pos].Type != CloseParen {
-			return "", errors.New("expected right parenthesis")
+		if p.peek(0).Type != CloseParen {
+			return nil, p.errorf(`")"`)
 		}
 		p.pos++
-
-		if p.pos < len(p.tokens) {
-			switch p.tokens[p.pos].Type {
-			case Eq, Ne, Gt, Gte, Lt, Lte:
-				left := expr
-				operator := p.tokens[p.pos]
-				p.pos++
-				right, err := p.additive()
-				if err != nil {
-					return "", err
-				}
-				switch operator.Type {
-				case Eq:
-					return fmt.Sprintf("eq(%s,%s)", left, right), nil
-				case Ne:
-					return fmt.Sprintf("ne(%s,%s)", left, right), nil
-				case Gt:
-					return fmt.Sprintf("gt(%s,%s)", left, right), nil
-				case Gte:
-					return fmt.Sprintf("gte(%s,%s)", left, right), nil
-				case Lt:
-					return fmt.Sprintf("lt(%s,%s)", left, right), nil
-				case Lte:
-					return fmt.Sprintf("lte(%s,%s)", left, right), nil
-				}
-			}
-		}
 		return expr, nil
-	}
-
-	left, err := p.additive()
-	if err != nil {
-		return "", err
-	}
-
-	if p.pos < len(p.tokens) {
-		switch p.tokens[p.pos].Type {
-		case Eq:
-			p.pos++
-			right, err := p.additive()
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("eq(%s,%s)", left, right), nil
-		case Ne:
-			p.pos++
-			right, err := p.additive()
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("ne(%s,%s)", left, right), nil
-		case Gt:
-			p.pos++
-			right, err := p.additive()
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("gt(%s,%s)", left, right), nil
-		case Gte:
-			p.pos++
-			right, err := p.additive()
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("gte(%s,%s)", left, right), nil
-		case Lt:
-			p.pos++
-			right, err := p.additive()
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("lt(%s,%s)", left, right), nil
-		case Lte:
+	case At:
+		return p.call()
+	case Dollar:
+		return p.variable()
+	case Literal:
+		p.pos++
+		return newLiteral(p.input, tok, false)
+	case Identifier:
+		if kind, ok := keywords[tok.Value]; ok {
+			tok.Kind = kind
 			p.pos++
-			right, err := p.additive()
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("lte(%s,%s)", left, right), nil
+			return newLiteral(p.input, tok, false)
 		}
 	}
-	return left, nil
+	return nil, p.errorf(expOperand)
 }
 
-// additive handles addition and subtraction operations
-func (p *parser) additive() (string, error) {
-	term, err := p.term()
-	if err != nil {
-		return "", err
+// call handles an @name(args...) function call
+func (p *parser) call() (Node, error) {
+	start := p.peek(0).Pos
+	p.pos++
+	if p.peek(0).Type != Identifier {
+		return nil, p.errorf(expFuncName)
 	}
-	for p.pos < len(p.tokens) {
-		switch p.tokens[p.pos].Type {
-		case Add:
-			p.pos++
-			right, err := p.term()
-			if err != nil {
-				return "", err
-			}
-			term = fmt.Sprintf("add(%s,%s)", term, right)
-		case Sub:
-			p.pos++
-			right, err := p.term()
-			if err != nil {
-				return "", err
-			}
-			term = fmt.Sprintf("sub(%s,%s)", term, right)
-		default:
-			return term, nil
+	name := p.peek(0).Value
+	p.pos++
+	if p.peek(0).Type != OpenParen {
+		return nil, p.errorf(`"("`)
+	}
+	p.pos++
+	args := []Node{}
+	for p.peek(0).Type != CloseParen {
+		arg, err := p.expression(lowestPrec)
+		if err != nil {
+			return nil, err
 		}
+		args = append(args, arg)
+		if p.peek(0).Type == CloseParen {
+			break
+		}
+		if p.peek(0).Type != Comma {
+			return nil, p.errorf(`","`, `")"`)
+		}
+		p.pos++
 	}
-	return term, nil
+	end := p.peek(0).End
+	p.pos++
+	return &CallExpr{Name: name, Args: args, Loc: Span{Start: start, End: end}}, nil
 }
 
-// term handles multiplication, division, and modulo operations
-func (p *parser) term() (string, error) {
-	factor, err := p.factor()
-	if err != nil {
-		return "", err
+// variable handles a $name reference followed by an optional path of
+// .field and [index] elements
+func (p *parser) variable() (Node, error) {
+	if p.peek(1).Type != Identifier {
+		p.pos++
+		return nil, p.errorf(expVarName)
+	}
+	expr := &VariableExpr{
+		Name: p.peek(1).Value,
+		Loc:  Span{Start: p.peek(0).Pos, End: p.peek(1).End},
 	}
-	for p.pos < len(p.tokens) {
-		switch p.tokens[p.pos].Type {
-		case Mul:
+	p.pos += 2
+
+	for {
+		start := p.peek(0).Pos
+		switch p.peek(0).Type {
+		case Dot:
 			p.pos++
-			right, err := p.factor()
-			if err != nil {
-				return "", err
+			if p.peek(0).Type != Identifier {
+				return nil, p.errorf(expFieldName)
 			}
-			factor = fmt.Sprintf("multi(%s,%s)", factor, right)
-		case Div:
+			expr.Path = append(expr.Path, PathElem{Key: p.peek(0).Value, Loc: Span{Start: start, End: p.peek(0).End}})
+		case OpenBracket:
 			p.pos++
-			right, err := p.factor()
+			tok := p.peek(0)
+			if tok.Type != Literal || (tok.Kind != IntLiteral && tok.Kind != StringLiteral) {
+				return nil, p.errorf(expIndex)
+			}
+			lit, err := newLiteral(p.input, tok, false)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
-			factor = fmt.Sprintf("div(%s,%s)", factor, right)
-		case Mod:
 			p.pos++
-			right, err := p.factor()
-			if err != nil {
-				return "", err
+			if p.peek(0).Type != CloseBracket {
+				return nil, p.errorf(`"]"`)
 			}
-			factor = fmt.Sprintf("mod(%s,%s)", factor, right)
+			expr.Path = append(expr.Path, PathElem{Key: lit.Value, Loc: Span{Start: start, End: p.peek(0).End}})
 		default:
-			return factor, nil
+			return expr, nil
 		}
+		expr.Loc.End = p.peek(0).End
+		p.pos++
 	}
-	return factor, nil
 }
 
-// factor handles parentheses, function calls, variables, and literals
-func (p *parser) factor() (string, error) {
-	if p.tokens[p.pos].Type == OpenParen {
-		p.pos++
-		expr, err := p.additive()
-		if err != nil {
-			return "", err
-		}
-		if p.tokens[p.pos].Type != CloseParen {
-			return "", errors.New("expected )")
-		}
+// negation handles prefix minus, a negated number becomes a negative literal
+func (p *parser) negation() (Node, error) {
+	start := p.peek(0).Pos
+	p.pos++
+
+	if tok := p.peek(0); tok.Type == Literal && (tok.Kind == IntLiteral || tok.Kind == FloatLiteral) {
 		p.pos++
-		return expr, nil
+		tok.Pos = start
+		return newLiteral(p.input, tok, true)
 	}
-	if p.tokens[p.pos].Type == At {
-		p.pos++
-		if p.tokens[p.pos].Type != Identifier {
-			return "", errors.New("expected function name")
-		}
-		name := p.tokens[p.pos].Value
-		p.pos++
-		if p.tokens[p.pos].Type != OpenParen {
-			return "", errors.New("expected (")
-		}
-		p.pos++
-		args := []string{}
-		for p.tokens[p.pos].Type != CloseParen {
-			arg, err := p.parseLogicalExpression()
-			if err != nil {
-				return "", err
-			}
-			args = append(args, arg)
-			if p.tokens[p.pos].Type == CloseParen {
-				break
-			}
-			if p.tokens[p.pos].Type != Comma {
-				return "", errors.New("expected ,")
-			}
-			p.pos++
-		}
-		if p.tokens[p.pos].Type != CloseParen {
-			return "", errors.New("expected )")
-		}
-		p.pos++
-		return fmt.Sprintf("%s(%s)", name, strings.Join(args, ",")), nil
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
 	}
-	if p.tokens[p.pos].Type == Dollar {
-		if p.tokens[p.pos+1].Type != Identifier {
-			return "", errors.New("expected variable name")
-		}
-		name := p.tokens[p.pos].Value + p.tokens[p.pos+1].Value
-		p.pos += 2
-		if p.pos < len(p.tokens) && p.tokens[p.pos].Type == Add {
-			p.pos++
-			right, err := p.term()
-			if err != nil {
-				return "", err
-			}
-			name = fmt.Sprintf("add(%s,%s)", name, right)
-		}
-		return name, nil
+	return &UnaryExpr{Op: Sub, X: x, Loc: Span{Start: start, End: x.Span().End}}, nil
+}
+
+// keywords are identifiers that stand for literals
+var keywords = map[string]LiteralKind{
+	"true":  BoolLiteral,
+	"false": BoolLiteral,
+	"null":  NullLiteral,
+}
+
+// newLiteral converts a literal token into a node holding its typed value
+func newLiteral(input string, tok Token, negative bool) (*LiteralExpr, error) {
+	lit := &LiteralExpr{
+		Kind: tok.Kind,
+		Raw:  input[tok.Pos:tok.End],
+		Loc:  Span{Start: tok.Pos, End: tok.End},
 	}
-	if p.tokens[p.pos].Type == Literal {
-		value := p.tokens[p.pos].Value
-		p.pos++
-		return value, nil
+	number := tok.Value
+	if negative {
+		number = "-" + number
+	}
+
+	var err error
+	switch tok.Kind {
+	case IntLiteral:
+		lit.Value, err = strconv.ParseInt(number, 10, 64)
+	case FloatLiteral:
+		lit.Value, err = strconv.ParseFloat(number, 64)
+	case BoolLiteral:
+		lit.Value = tok.Value == "true"
+	case NullLiteral:
+		lit.Value = nil
+	default:
+		lit.Value = tok.Value
 	}
-	return "", errors.New("expected literal")
+	if err != nil {
+		return nil, &Error{Pos: tok.Pos, Token: lit.Raw, Expected: []string{"number"}}
+	}
+	return lit, nil
+}
+
+// byteAt returns input[i], or 0 past the end of input
+func byteAt(input string, i int) byte {
+	if i < len(input) {
+		return input[i]
+	}
+	return 0
 }
 
 // tokenize converts the input string into a sequence of tokens
 // Handles operators, numbers, strings, identifiers, and special characters
 func tokenize(input string) ([]Token, error) {
 	tokens := []Token{}
+	emit := func(typ TokenType, value string, pos int) {
+		tokens = append(tokens, Token{Type: typ, Value: value, Pos: pos, End: pos + len(value)})
+	}
 	for i := 0; i < len(input); i++ {
 		switch {
 		case input[i] == '+':
-			tokens = append(tokens, Token{Add, "+"})
+			emit(Add, "+", i)
 		case input[i] == '-':
-			tokens = append(tokens, Token{Sub, "-"})
+			emit(Sub, "-", i)
 		case input[i] == '*':
-			tokens = append(tokens, Token{Mul, "*"})
+			emit(Mul, "*", i)
 		case input[i] == '/':
-			tokens = append(tokens, Token{Div, "/"})
+			emit(Div, "/", i)
 		case input[i] == '%':
-			tokens = append(tokens, Token{Mod, "%"})
+			emit(Mod, "%", i)
 		case input[i] == '(':
-			tokens = append(tokens, Token{OpenParen, "("})
+			emit(OpenParen, "(", i)
 		case input[i] == ')':
-			tokens = append(tokens, Token{CloseParen, ")"})
+			emit(CloseParen, ")", i)
 		case input[i] == '@':
-			tokens = append(tokens, Token{At, "@"})
+			emit(At, "@", i)
 		case input[i] == '$':
-			tokens = append(tokens, Token{Dollar, "$"})
+			emit(Dollar, "$", i)
 		case input[i] == ',':
-			tokens = append(tokens, Token{Comma, ","})
+			emit(Comma, ",", i)
+		case input[i] == '.':
+			emit(Dot, ".", i)
+		case input[i] == '?':
+			if byteAt(input, i+1) == '?' {
+				emit(Coalesce, "??", i)
+				i++
+			} else {
+				emit(Question, "?", i)
+			}
+		case input[i] == ':':
+			emit(Colon, ":", i)
+		case input[i] == '[':
+			emit(OpenBracket, "[", i)
+		case input[i] == ']':
+			emit(CloseBracket, "]", i)
 		case input[i] == '&':
-			if input[i+1] == '&' {
-				tokens = append(tokens, Token{And, "&&"})
+			if byteAt(input, i+1) == '&' {
+				emit(And, "&&", i)
 				i++
 			} else {
-				return nil, errors.New("expected &&")
+				return nil, &Error{Pos: i, Token: "&", Expected: []string{`"&&"`}}
 			}
 		case input[i] == '|':
-			if input[i+1] == '|' {
-				tokens = append(tokens, Token{Or, "||"})
+			if byteAt(input, i+1) == '|' {
+				emit(Or, "||", i)
 				i++
 			} else {
-				return nil, errors.New("expected ||")
+				return nil, &Error{Pos: i, Token: "|", Expected: []string{`"||"`}}
 			}
 		case input[i] == '!':
-			if input[i+1] == '=' {
-				tokens = append(tokens, Token{Ne, "!="})
+			if byteAt(input, i+1) == '=' {
+				emit(Ne, "!=", i)
 				i++
 			} else {
-				tokens = append(tokens, Token{Not, "!"})
+				emit(Not, "!", i)
 			}
 		case input[i] == '>':
-			if input[i+1] == '=' {
-				tokens = append(tokens, Token{Gte, ">="})
+			if byteAt(input, i+1) == '=' {
+				emit(Gte, ">=", i)
 				i++
 			} else {
-				tokens = append(tokens, Token{Gt, ">"})
+				emit(Gt, ">", i)
 			}
 		case input[i] == '<':
-			if input[i+1] == '=' {
-				tokens = append(tokens, Token{Lte, "<="})
+			if byteAt(input, i+1) == '=' {
+				emit(Lte, "<=", i)
 				i++
 			} else {
-				tokens = append(tokens, Token{Lt, "<"})
+				emit(Lt, "<", i)
 			}
 		case input[i] == '=':
-			if i+1 < len(input) && input[i+1] == '=' {
-				tokens = append(tokens, Token{Eq, "=="})
+			if byteAt(input, i+1) == '=' {
+				emit(Eq, "==", i)
 				i++
 			} else {
-				return nil, errors.New("expected ==")
+				return nil, &Error{Pos: i, Token: "=", Expected: []string{`"=="`}}
 			}
-		case input[i] == '"':
-			j := i + 1
-			for j < len(input) && input[j] != '"' {
-				j++
-			}
-			if j == len(input) {
-				return nil, errors.New(`expected "`)
+		case input[i] == '"', input[i] == '\'', input[i] == '`':
+			token, err := scanString(input, i)
+			if err != nil {
+				return nil, err
 			}
-			tokens = append(tokens, Token{Literal, input[i+1:j] + `:str`})
-			i = j
-		case unicode.IsLetter(rune(input[i])):
+			tokens = append(tokens, token)
+			i = token.End - 1
+		case startsIdentifier(input[i:]):
 			j := i
-			for j < len(input) && (unicode.IsLetter(rune(input[j])) || unicode.IsDigit(rune(input[j])) || input[j] == '_') {
-				j++
+			for j < len(input) {
+				r, size := utf8.DecodeRuneInString(input[j:])
+				if !isIdentifierRune(r) {
+					break
+				}
+				j += size
 			}
-			tokens = append(tokens, Token{Identifier, input[i:j]})
+			emit(Identifier, input[i:j], i)
 			i = j - 1
-		case unicode.IsDigit(rune(input[i])):
+		case '0' <= input[i] && input[i] <= '9':
 			j := i
-			for j < len(input) && (unicode.IsDigit(rune(input[j])) || input[j] == '.') {
+			for j < len(input) && ('0' <= input[j] && input[j] <= '9' || input[j] == '.') {
 				j++
 			}
 
-			token := input[i:j]
-			if strings.Contains(token, ".") {
-				tokens = append(tokens, Token{Literal, token + `:float`})
-			} else {
-				tokens = append(tokens, Token{Literal, token + `:int`})
+			token := Token{Type: Literal, Value: input[i:j], Kind: IntLiteral, Pos: i, End: j}
+			if strings.Contains(token.Value, ".") {
+				token.Kind = FloatLiteral
 			}
+			tokens = append(tokens, token)
 			i = j - 1
-		case input[i] == ' ':
+		case input[i] == ' ', input[i] == '\t', input[i] == '\n', input[i] == '\r':
 			continue
 		default:
-			return nil, fmt.Errorf("unexpected character: %c", input[i])
+			_, size := utf8.DecodeRuneInString(input[i:])
+			return nil, &Error{Pos: i, Token: input[i : i+size]}
 		}
 	}
 	return tokens, nil
 }
+
+// startsIdentifier reports whether s begins with a letter or underscore
+func startsIdentifier(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLetter(r) || r == '_'
+}
+
+// isIdentifier reports whether s is a valid identifier
+func isIdentifier(s string) bool {
+	if !startsIdentifier(s) {
+		return false
+	}
+	for _, r := range s {
+		if !isIdentifierRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isIdentifierRune reports whether r can continue an identifier
+func isIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// scanString scans the string literal starting at input[i]. Double and
+// single quoted strings support Go escape sequences, backquoted strings are
+// raw and may span lines.
+func scanString(input string, i int) (Token, error) {
+	quote := input[i]
+	j := i + 1
+	if quote == '`' {
+		end := strings.IndexByte(input[j:], '`')
+		if end == -1 {
+			return Token{}, &Error{Pos: len(input), Expected: []string{"closing `"}}
+		}
+		return Token{Type: Literal, Value: input[j : j+end], Kind: StringLiteral, Pos: i, End: j + end + 1}, nil
+	}
+
+	var b strings.Builder
+	for {
+		if j >= len(input) {
+			return Token{}, &Error{Pos: len(input), Expected: []string{"closing " + string(quote)}}
+		}
+		if input[j] == quote {
+			break
+		}
+		if input[j] != '\\' {
+			b.WriteByte(input[j])
+			j++
+			continue
+		}
+
+		r, multibyte, tail, err := strconv.UnquoteChar(input[j:], quote)
+		if err != nil {
+			_, size := utf8.DecodeRuneInString(input[j+1:])
+			return Token{}, &Error{Pos: j, Token: input[j : j+1+size], Expected: []string{"escape sequence"}}
+		}
+		// \x and octal escapes produce single bytes like in Go
+		if r < utf8.RuneSelf || !multibyte {
+			b.WriteByte(byte(r))
+		} else {
+			b.WriteRune(r)
+		}
+		j = len(input) - len(tail)
+	}
+	return Token{Type: Literal, Value: b.String(), Kind: StringLiteral, Pos: i, End: j + 1}, nil
+}
@@ -1,6 +1,9 @@
 package parser
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestConvertExpression(t *testing.T) {
 	type args struct {
@@ -17,77 +20,77 @@ func TestConvertExpression(t *testing.T) {
 			args: args{
 				expr: "1+2",
 			},
-			want: "add(1:int,2:int)",
+			want: "add(1,2)",
 		},
 		{
 			name: "test2",
 			args: args{
 				expr: "1+2*3",
 			},
-			want: "add(1:int,multi(2:int,3:int))",
+			want: "add(1,multi(2,3))",
 		},
 		{
 			name: "test3",
 			args: args{
 				expr: "1+2*3-4",
 			},
-			want: "sub(add(1:int,multi(2:int,3:int)),4:int)",
+			want: "sub(add(1,multi(2,3)),4)",
 		},
 		{
 			name: "test4",
 			args: args{
 				expr: "1+2*3-4/5",
 			},
-			want: "sub(add(1:int,multi(2:int,3:int)),div(4:int,5:int))",
+			want: "sub(add(1,multi(2,3)),div(4,5))",
 		},
 		{
 			name: "test5",
 			args: args{
 				expr: "1+2*3-4/5+@sum(1,2,3)",
 			},
-			want: "add(sub(add(1:int,multi(2:int,3:int)),div(4:int,5:int)),sum(1:int,2:int,3:int))",
+			want: "add(sub(add(1,multi(2,3)),div(4,5)),sum(1,2,3))",
 		},
 		{
 			name: "test6",
 			args: args{
 				expr: "1+2*@funA($a,2)-3",
 			},
-			want: "sub(add(1:int,multi(2:int,funA($a,2:int))),3:int)",
+			want: "sub(add(1,multi(2,funA($a,2))),3)",
 		},
 		{
 			name: "test7",
 			args: args{
 				expr: "1+2*@funA($a,2)-@funB(3,4)",
 			},
-			want: "sub(add(1:int,multi(2:int,funA($a,2:int))),funB(3:int,4:int))",
+			want: "sub(add(1,multi(2,funA($a,2))),funB(3,4))",
 		},
 		{
 			name: "test8",
 			args: args{
 				expr: "1+2*@funA($a)-@funB(3,4)+@funC(5,6)",
 			},
-			want: "add(sub(add(1:int,multi(2:int,funA($a))),funB(3:int,4:int)),funC(5:int,6:int))",
+			want: "add(sub(add(1,multi(2,funA($a))),funB(3,4)),funC(5,6))",
 		},
 		{
 			name: "test9",
 			args: args{
 				expr: `$a+"s"`,
 			},
-			want: "add($a,s:str)",
+			want: `add($a,"s")`,
 		},
 		{
 			name: "test10",
 			args: args{
 				expr: `$a+"s"+$b`,
 			},
-			want: "add(add($a,s:str),$b)",
+			want: `add(add($a,"s"),$b)`,
 		},
 		{
 			name: "test11",
 			args: args{
 				expr: `$a+"s"+$b+"t"`,
 			},
-			want: "add(add($a,s:str),add($b,t:str))",
+			want: `add(add(add($a,"s"),$b),"t")`,
 		},
 
 		{
@@ -95,77 +98,294 @@ func TestConvertExpression(t *testing.T) {
 			args: args{
 				expr: `$a+"s"+$b+"t"+$c`,
 			},
-			want: "add(add(add($a,s:str),add($b,t:str)),$c)",
+			want: `add(add(add(add($a,"s"),$b),"t"),$c)`,
 		},
 		{
 			name: "test13",
 			args: args{
 				expr: `@funA($a+1,2)`,
 			},
-			want: "funA(add($a,1:int),2:int)",
+			want: "funA(add($a,1),2)",
 		},
 		{
 			name: "test14",
 			args: args{
 				expr: "1+2*@funA($a+1,$b)",
 			},
-			want: "add(1:int,multi(2:int,funA(add($a,1:int),$b)))",
+			want: "add(1,multi(2,funA(add($a,1),$b)))",
 		},
 		{
 			name: "test15",
 			args: args{
 				expr: "@funA($a+1,$b)",
 			},
-			want: "funA(add($a,1:int),$b)",
+			want: "funA(add($a,1),$b)",
 		},
 		{
 			name: "test16",
 			args: args{
 				expr: "1*(2+3)",
 			},
-			want: "multi(1:int,add(2:int,3:int))",
+			want: "multi(1,add(2,3))",
 		},
 		{
 			name: "test17",
 			args: args{
 				expr: "$a > 1 && $b < 2",
 			},
-			want: "and(gt($a,1:int),lt($b,2:int))",
+			want: "and(gt($a,1),lt($b,2))",
 		},
 		{
 			name: "test18",
 			args: args{
 				expr: "($a > 1 && $b < 2) || $c == 3",
 			},
-			want: "or(and(gt($a,1:int),lt($b,2:int)),eq($c,3:int))",
+			want: "or(and(gt($a,1),lt($b,2)),eq($c,3))",
 		},
 		{
 			name: "test19",
 			args: args{
 				expr: "!($a > 1 && $b < 2)",
 			},
-			want: "not(and(gt($a,1:int),lt($b,2:int)))",
+			want: "not(and(gt($a,1),lt($b,2)))",
 		},
 		{
 			name: "test20",
 			args: args{
 				expr: "($a+1)>=10",
 			},
-			want: "gte(add($a,1:int),10:int)",
+			want: "gte(add($a,1),10)",
 		},
 		{
 			name: "test21",
 			args: args{
 				expr: `($stock>100 && $stock<200) && $mfr=="motorola"`,
 			},
-			want: "and(and(gt($stock,100:int),lt($stock,200:int)),eq($mfr,motorola:str))",
+			want: `and(and(gt($stock,100),lt($stock,200)),eq($mfr,"motorola"))`,
 		},
 		{
 			name: "test22",
 			args: args{
 				expr: `($stock>100 && $stock<200) || $mfr=="motorola"`,
 			},
-			want: "or(and(gt($stock,100:int),lt($stock,200:int)),eq($mfr,motorola:str))",
+			want: `or(and(gt($stock,100),lt($stock,200)),eq($mfr,"motorola"))`,
+		},
+		{
+			name: "test23",
+			args: args{
+				expr: `$a+"x,y"`,
+			},
+			want: `add($a,"x,y")`,
+		},
+		{
+			name: "test24",
+			args: args{
+				expr: `@funA("(a:b)",1.5)`,
+			},
+			want: `funA("(a:b)",1.5)`,
+		},
+		{
+			name: "test25",
+			args: args{
+				expr: `$discount > -5`,
+			},
+			want: `gt($discount,-5)`,
+		},
+		{
+			name: "test26",
+			args: args{
+				expr: `-$x*2`,
+			},
+			want: `multi(neg($x),2)`,
+		},
+		{
+			name: "test27",
+			args: args{
+				expr: `-(1+2)`,
+			},
+			want: `neg(add(1,2))`,
+		},
+		{
+			name: "test28",
+			args: args{
+				expr: `$flag == true && !false`,
+			},
+			want: `and(eq($flag,true),not(false))`,
+		},
+		{
+			name: "test29",
+			args: args{
+				expr: `$a != null`,
+			},
+			want: `ne($a,null)`,
+		},
+		{
+			name: "test30",
+			args: args{
+				expr: `$true - -1.5`,
+			},
+			want: `sub($true,-1.5)`,
+		},
+		{
+			name: "test31",
+			args: args{
+				expr: `@null(-$x + 1)`,
+			},
+			want: `null(add(neg($x),1))`,
+		},
+		{
+			name: "test32",
+			args: args{
+				expr: `$order.customer.tier == "gold"`,
+			},
+			want: `eq($order.customer.tier,"gold")`,
+		},
+		{
+			name: "test33",
+			args: args{
+				expr: `$items[0].price * $items[0] . qty`,
+			},
+			want: `multi($items[0].price,$items[0].qty)`,
+		},
+		{
+			name: "test34",
+			args: args{
+				expr: `@hasPrefix($attrs["color"], $attrs['main color'])`,
+			},
+			want: `hasPrefix($attrs.color,$attrs["main color"])`,
+		},
+		{
+			name: "test35",
+			args: args{
+				expr: `2*$a+1`,
+			},
+			want: `add(multi(2,$a),1)`,
+		},
+		{
+			name: "test36",
+			args: args{
+				expr: `$a+2*$b`,
+			},
+			want: `add($a,multi(2,$b))`,
+		},
+		{
+			name: "test37",
+			args: args{
+				expr: `$a||$b&&$c`,
+			},
+			want: `or($a,and($b,$c))`,
+		},
+		{
+			name: "test38",
+			args: args{
+				expr: `$a&&$b||$c&&$d`,
+			},
+			want: `or(and($a,$b),and($c,$d))`,
+		},
+		{
+			name: "test39",
+			args: args{
+				expr: `!$a==$b`,
+			},
+			want: `eq(not($a),$b)`,
+		},
+		{
+			name: "test40",
+			args: args{
+				expr: `$a<$b<$c`,
+			},
+			want: `lt(lt($a,$b),$c)`,
+		},
+		{
+			name: "test41",
+			args: args{
+				expr: `($a)>($b)==true`,
+			},
+			want: `eq(gt($a,$b),true)`,
+		},
+		{
+			name: "test42",
+			args: args{
+				expr: `$a-$b-$c`,
+			},
+			want: `sub(sub($a,$b),$c)`,
+		},
+		{
+			name: "test43",
+			args: args{
+				expr: `$a/$b*$c%2`,
+			},
+			want: `mod(multi(div($a,$b),$c),2)`,
+		},
+		{
+			name: "test44",
+			args: args{
+				expr: `-$a*$b`,
+			},
+			want: `multi(neg($a),$b)`,
+		},
+		{
+			name: "test45",
+			args: args{
+				expr: `$a+1>$b-1==!$c`,
+			},
+			want: `eq(gt(add($a,1),sub($b,1)),not($c))`,
+		},
+		{
+			name: "test46",
+			args: args{
+				expr: `(1+2)*3`,
+			},
+			want: `multi(add(1,2),3)`,
+		},
+		{
+			name: "test47",
+			args: args{
+				expr: `$a ? 1 : 2`,
+			},
+			want: `if($a,1,2)`,
+		},
+		{
+			name: "test48",
+			args: args{
+				expr: `$a > 1 ? $b : $c ? 2 : 3`,
+			},
+			want: `if(gt($a,1),$b,if($c,2,3))`,
+		},
+		{
+			name: "test49",
+			args: args{
+				expr: `$a || $b ? $c + 1 : -1`,
+			},
+			want: `if(or($a,$b),add($c,1),-1)`,
+		},
+		{
+			name: "test50",
+			args: args{
+				expr: `$a ? $b ? 1 : 2 : 3`,
+			},
+			want: `if($a,if($b,1,2),3)`,
+		},
+		{
+			name: "test51",
+			args: args{
+				expr: `$x ?? 0 + 1`,
+			},
+			want: `add(coalesce($x,0),1)`,
+		},
+		{
+			name: "test52",
+			args: args{
+				expr: `$x ?? $y ?? 2 * 3`,
+			},
+			want: `multi(coalesce(coalesce($x,$y),2),3)`,
+		},
+		{
+			name: "test53",
+			args: args{
+				expr: `@f($a ? 1 : 2, $b ?? 3)`,
+			},
+			want: `f(if($a,1,2),coalesce($b,3))`,
 		},
 	}
 	for _, tt := range tests {
@@ -176,10 +396,320 @@ func TestConvertExpression(t *testing.T) {
 				return
 			}
 
-			if got != tt.want {
+			if got.String() != tt.want {
 				t.Errorf("ConvertExpression() = %s", tt.args.expr)
 				t.Errorf("ConvertExpression() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestParseTree(t *testing.T) {
+	node, err := Parse(`@funA($a, "x,y") * 2`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	mul, ok := node.(*BinaryExpr)
+	if !ok || mul.Op != Mul {
+		t.Fatalf("Parse() = %#v, want multiplication", node)
+	}
+	if mul.Span() != (Span{Start: 0, End: 20}) {
+		t.Errorf("Span() = %v, want {0 20}", mul.Span())
+	}
+
+	call, ok := mul.Left.(*CallExpr)
+	if !ok || call.Name != "funA" || len(call.Args) != 2 {
+		t.Fatalf("Left = %#v, want call to funA with 2 args", mul.Left)
+	}
+	if v, ok := call.Args[0].(*VariableExpr); !ok || v.Name != "a" || v.Span() != (Span{Start: 6, End: 8}) {
+		t.Errorf("Args[0] = %#v, want $a at {6 8}", call.Args[0])
+	}
+	if lit, ok := call.Args[1].(*LiteralExpr); !ok || lit.Kind != StringLiteral || lit.Value != "x,y" || lit.Raw != `"x,y"` {
+		t.Errorf("Args[1] = %#v, want string literal x,y", call.Args[1])
+	}
+	if lit, ok := mul.Right.(*LiteralExpr); !ok || lit.Kind != IntLiteral || lit.Value != int64(2) {
+		t.Errorf("Right = %#v, want int literal 2", mul.Right)
+	}
+}
+
+func TestParseVariablePath(t *testing.T) {
+	node, err := Parse(`$items[1]["unit price"].amount`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	v, ok := node.(*VariableExpr)
+	if !ok || v.Name != "items" {
+		t.Fatalf("Parse() = %#v, want $items", node)
+	}
+	want := []PathElem{
+		{Key: int64(1), Loc: Span{Start: 6, End: 9}},
+		{Key: "unit price", Loc: Span{Start: 9, End: 23}},
+		{Key: "amount", Loc: Span{Start: 23, End: 30}},
+	}
+	if !reflect.DeepEqual(v.Path, want) {
+		t.Errorf("Path = %v, want %v", v.Path, want)
+	}
+	if v.Span() != (Span{Start: 0, End: 30}) {
+		t.Errorf("Span() = %v, want {0 30}", v.Span())
+	}
+	if got := v.Ref(); got != `items[1]["unit price"].amount` {
+		t.Errorf("Ref() = %s", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		want  Error
+		error string
+	}{
+		{
+			name:  "test1",
+			expr:  "1 + * 2",
+			want:  Error{Pos: 4, Token: "*", Expected: []string{expOperand}},
+			error: `expected operand, found "*"`,
+		},
+		{
+			name:  "test2",
+			expr:  "@funA(1 2)",
+			want:  Error{Pos: 8, Token: "2", Expected: []string{`","`, `")"`}},
+			error: `expected "," or ")", found "2"`,
+		},
+		{
+			name:  "test3",
+			expr:  "($a > 1",
+			want:  Error{Pos: 7, Expected: []string{`")"`}},
+			error: `expected ")", found end of input`,
+		},
+		{
+			name:  "test4",
+			expr:  "$a $b",
+			want:  Error{Pos: 3, Token: "$", Expected: []string{expOperator}},
+			error: `expected operator, found "$"`,
+		},
+		{
+			name:  "test5",
+			expr:  `$a == "abc`,
+			want:  Error{Pos: 10, Expected: []string{`closing "`}},
+			error: `expected closing ", found end of input`,
+		},
+		{
+			name:  "test6",
+			expr:  "$a = 1",
+			want:  Error{Pos: 3, Token: "=", Expected: []string{`"=="`}},
+			error: `expected "==", found "="`,
+		},
+		{
+			name:  "test7",
+			expr:  "$a > 99999999999999999999",
+			want:  Error{Pos: 5, Token: "99999999999999999999", Expected: []string{"number"}},
+			error: `expected number, found "99999999999999999999"`,
+		},
+		{
+			name:  "test8",
+			expr:  "1.2.3 + 1",
+			want:  Error{Pos: 0, Token: "1.2.3", Expected: []string{"number"}},
+			error: `expected number, found "1.2.3"`,
+		},
+		{
+			name:  "test9",
+			expr:  "$a + #",
+			want:  Error{Pos: 5, Token: "#"},
+			error: `unexpected "#"`,
+		},
+		{
+			name:  "test10",
+			expr:  "$order. > 1",
+			want:  Error{Pos: 8, Token: ">", Expected: []string{expFieldName}},
+			error: `expected field name, found ">"`,
+		},
+		{
+			name:  "test11",
+			expr:  "$items[$i]",
+			want:  Error{Pos: 7, Token: "$", Expected: []string{expIndex}},
+			error: `expected index, found "$"`,
+		},
+		{
+			name:  "test12",
+			expr:  "$items[0",
+			want:  Error{Pos: 8, Expected: []string{`"]"`}},
+			error: `expected "]", found end of input`,
+		},
+		{
+			name:  "test13",
+			expr:  "$a ? 1",
+			want:  Error{Pos: 6, Expected: []string{`":"`}},
+			error: `expected ":", found end of input`,
+		},
+		{
+			name:  "test14",
+			expr:  "$a ?? : 1",
+			want:  Error{Pos: 6, Token: ":", Expected: []string{expOperand}},
+			error: `expected operand, found ":"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			perr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("Parse() error = %v, want *Error", err)
+			}
+			if !reflect.DeepEqual(*perr, tt.want) {
+				t.Errorf("Parse() error = %#v, want %#v", *perr, tt.want)
+			}
+			if perr.Error() != tt.error {
+				t.Errorf("Error() = %s, want %s", perr.Error(), tt.error)
+			}
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"1+2*3-4/5+@sum(1,2,3)",
+		`$a+"s"+$b+"t"+$c`,
+		"!($a > 1 && $b < 2) || $c == 3",
+		`($stock>100 && $stock<200) && $mfr=="motorola"`,
+		"@funA($a+1,$b)",
+		"$a ? $b : $c ? 1 : 2", "$x ?? $y ?? 0",
+		"$a &", "$a |", "!", ">", "<", "@", "$", "@f(", "(", `"`, "?", "$a ?",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		node, err := Parse(input)
+		if err != nil {
+			perr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("Parse(%q) error = %v, want *Error", input, err)
+			}
+			if perr.Pos < 0 || perr.Pos > len(input) {
+				t.Fatalf("Parse(%q) error position %d out of range", input, perr.Pos)
+			}
+			return
+		}
+		if span := node.Span(); span.Start < 0 || span.End > len(input) || span.Start > span.End {
+			t.Fatalf("Parse(%q) span %v out of range", input, span)
+		}
+		_ = node.String()
+	})
+}
+
+func TestTokenizeStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Token
+	}{
+		{
+			name:  "test1",
+			input: `"a\"b\\c\n\t"`,
+			want:  []Token{{Type: Literal, Value: "a\"b\\c\n\t", Kind: StringLiteral, Pos: 0, End: 13}},
+		},
+		{
+			name:  "test2",
+			input: `'it\'s "ok"'`,
+			want:  []Token{{Type: Literal, Value: `it's "ok"`, Kind: StringLiteral, Pos: 0, End: 12}},
+		},
+		{
+			name:  "test3",
+			input: "`raw\\n,(x)`",
+			want:  []Token{{Type: Literal, Value: `raw\n,(x)`, Kind: StringLiteral, Pos: 0, End: 11}},
+		},
+		{
+			name:  "test4",
+			input: `"caf\u00e9 \U0001F600"`,
+			want:  []Token{{Type: Literal, Value: "café 😀", Kind: StringLiteral, Pos: 0, End: 22}},
+		},
+		{
+			name:  "test5",
+			input: `"Zoë"`,
+			want:  []Token{{Type: Literal, Value: "Zoë", Kind: StringLiteral, Pos: 0, End: 6}},
+		},
+		{
+			name:  "test6",
+			input: `$größe_2`,
+			want: []Token{
+				{Type: Dollar, Value: "$", Pos: 0, End: 1},
+				{Type: Identifier, Value: "größe_2", Pos: 1, End: 10},
+			},
+		},
+		{
+			name:  "test7",
+			input: `$数量`,
+			want: []Token{
+				{Type: Dollar, Value: "$", Pos: 0, End: 1},
+				{Type: Identifier, Value: "数量", Pos: 1, End: 7},
+			},
+		},
+		{
+			name:  "test8",
+			input: `$_a`,
+			want: []Token{
+				{Type: Dollar, Value: "$", Pos: 0, End: 1},
+				{Type: Identifier, Value: "_a", Pos: 1, End: 3},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tokenize(tt.input)
+			if err != nil {
+				t.Fatalf("tokenize() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenize() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeStringErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Error
+	}{
+		{
+			name:  "test1",
+			input: `"a\qb"`,
+			want:  Error{Pos: 2, Token: `\q`, Expected: []string{"escape sequence"}},
+		},
+		{
+			name:  "test2",
+			input: `'abc`,
+			want:  Error{Pos: 4, Expected: []string{"closing '"}},
+		},
+		{
+			name:  "test3",
+			input: "`abc",
+			want:  Error{Pos: 4, Expected: []string{"closing `"}},
+		},
+		{
+			name:  "test4",
+			input: `"\u12"`,
+			want:  Error{Pos: 1, Token: `\u`, Expected: []string{"escape sequence"}},
+		},
+		{
+			name:  "test5",
+			input: "$a == \xff",
+			want:  Error{Pos: 6, Token: "\xff"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tokenize(tt.input)
+			perr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("tokenize() error = %v, want *Error", err)
+			}
+			if !reflect.DeepEqual(*perr, tt.want) {
+				t.Errorf("tokenize() error = %#v, want %#v", *perr, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package parser
+
+import "sync/atomic"
+
+// UndefinedMode controls what a $variable missing from vars evaluates to
+type UndefinedMode int
+
+const (
+	// UndefinedName evaluates to the variable name, the historical behavior
+	UndefinedName UndefinedMode = iota
+	// UndefinedNil evaluates to nil
+	UndefinedNil
+	// UndefinedError fails execution with an *UndefinedVariableError
+	UndefinedError
+)
+
+// RoundingMode selects how results are rounded to the decimal places of an
+// expression
+type RoundingMode int
+
+const (
+	// RoundHalfUp rounds halves away from zero, 2.5 to 3 and -2.5 to -3
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven rounds halves to the nearest even digit, 2.5 to 2, also
+	// known as banker's rounding
+	RoundHalfEven
+	// RoundFloor rounds towards negative infinity
+	RoundFloor
+	// RoundCeil rounds towards positive infinity
+	RoundCeil
+	// RoundTruncate rounds towards zero
+	RoundTruncate
+)
+
+// ArithmeticPolicy selects what an integer overflow or a division by zero
+// evaluates to
+type ArithmeticPolicy int
+
+const (
+	// PolicyError fails execution with ErrOverflow or ErrDivisionByZero
+	PolicyError ArithmeticPolicy = iota
+	// PolicyNull evaluates to nil
+	PolicyNull
+	// PolicySaturate evaluates to the nearest representable value: the
+	// largest or smallest int64, or an infinity for floats. 0 / 0 and x % 0
+	// evaluate to 0.
+	PolicySaturate
+	// PolicyWrap wraps integers around like Go does, for overflows only
+	PolicyWrap
+	// PolicyPromote evaluates to the exact result as a decimal.Decimal, for
+	// overflows only
+	PolicyPromote
+)
+
+// Option configures how an expression is parsed and executed
+type Option func(*options)
+
+type options struct {
+	undefined     UndefinedMode
+	decimalsPlace int32
+	sharedPlaces  *atomic.Int32 // Decimal places read at execution time instead, if set
+	rounding      RoundingMode
+	decimal       bool             // Numbers are computed as decimal.Decimal
+	overflow      ArithmeticPolicy // What int64 overflows evaluate to
+	divisionZero  ArithmeticPolicy // What divisions by zero evaluate to
+	schema        Schema           // Types variables are checked against, if set
+}
+
+var defaultOptions = options{
+	decimalsPlace: 6,
+}
+
+// places returns the decimal places results are rounded to
+func (o *options) places() int32 {
+	if o.sharedPlaces != nil {
+		return o.sharedPlaces.Load()
+	}
+	return o.decimalsPlace
+}
+
+// apply returns a copy of o with opts applied
+func (o options) apply(opts []Option) *options {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &o
+}
+
+// WithUndefined sets what missing variables evaluate to
+func WithUndefined(mode UndefinedMode) Option {
+	return func(o *options) {
+		o.undefined = mode
+	}
+}
+
+// Strict makes missing variables fail execution with an *UndefinedVariableError
+func Strict() Option {
+	return WithUndefined(UndefinedError)
+}
+
+// Lenient makes missing variables evaluate to nil
+func Lenient() Option {
+	return WithUndefined(UndefinedNil)
+}
+
+// WithDecimalsPlace sets the decimal places float results are rounded to
+func WithDecimalsPlace(place int32) Option {
+	return func(o *options) {
+		o.decimalsPlace = place
+		o.sharedPlaces = nil
+	}
+}
+
+// WithRounding sets how results are rounded to the decimal places
+func WithRounding(mode RoundingMode) Option {
+	return func(o *options) {
+		o.rounding = mode
+	}
+}
+
+// Decimal computes numbers as decimal.Decimal from end to end: numeric
+// literals are decimals, numeric variables are converted when they are
+// computed with, and arithmetic results stay decimals instead of being
+// rounded to float64 after every operation. Only divisions are rounded, to
+// the decimal places with the rounding mode. Results are decimal.Decimal,
+// call InexactFloat64 on them to get a float.
+func Decimal() Option {
+	return func(o *options) {
+		o.decimal = true
+	}
+}
+
+// WithOverflow sets what integer operations whose result does not fit in an
+// int64 evaluate to, they fail with ErrOverflow by default
+func WithOverflow(policy ArithmeticPolicy) Option {
+	return func(o *options) {
+		o.overflow = policy
+	}
+}
+
+// WithDivisionByZero sets what divisions and modulos by zero evaluate to,
+// they fail with ErrDivisionByZero by default. PolicyWrap and PolicyPromote
+// are treated as PolicyError.
+func WithDivisionByZero(policy ArithmeticPolicy) Option {
+	return func(o *options) {
+		o.divisionZero = policy
+	}
+}
+
+// WithSchema checks expressions against the declared types of variables
+// when they are parsed. Referencing an undeclared variable is an error, and
+// operands are no longer converted between strings, numbers and booleans.
+func WithSchema(schema Schema) Option {
+	return func(o *options) {
+		o.schema = schema
+	}
+}
+
+// FuncOption configures a registered function
+type FuncOption func(*funcDef)
+
+// Pure declares that a function has no side effects and always returns the
+// same result for the same arguments. Calls to it with constant arguments
+// are then evaluated once at parse time. The builtins are pure.
+func Pure() FuncOption {
+	return func(d *funcDef) {
+		d.pure = true
+	}
+}
@@ -3,8 +3,6 @@ package parser
 import (
 	"errors"
 	"fmt"
-
-	"github.com/go-parser/parser/internal/parser"
 	"github.com/spf13/cast"
 )
 
@@ -36,30 +34,30 @@ func (e *Expression) String() string {
 	return s
 }
 
-func ParseExpression(expr string) (*FunctionCall, error) {
-	expr, err := parser.Parse(expr)
-	if err != nil {
-		return nil, fmt.Errorf("parse %q error: %w", expr, err)
-	}
+// ParseExpression parses expr into an executable function call tree using
+// the default Env
+func ParseExpression(expr string, opts ...Option) (*FunctionCall, error) {
+	return defaultEnv.ParseExpression(expr, opts...)
+}
 
-	return ParseFunctionExpression(expr)
+// ParseAndExecute parses expr with the default Env and executes it with vars
+func ParseAndExecute(expr string, vars map[string]any, opts ...Option) (any, error) {
+	return defaultEnv.ParseAndExecute(expr, vars, opts...)
 }
 
-func ParseAndExecute(expr string, vars map[string]any) (any, error) {
-	f, err := ParseExpression(expr)
-	if err != nil {
-		return nil, err
-	}
-	return f.Execute(vars), nil
+// Parse parses the expressions using the default Env
+func (e *Expression) Parse(opts ...Option) error {
+	return e.ParseWith(defaultEnv, opts...)
 }
 
-func (e *Expression) Parse() error {
+// ParseWith parses the expressions using the functions and options of env
+func (e *Expression) ParseWith(env *Env, opts ...Option) error {
 	if e.Then == "" {
 		return fmt.Errorf("then is required")
 	}
 	// Parse condition
 	if e.If != "" {
-		expr, err := ParseExpression(e.If)
+		expr, err := parseCondition(env, e.If, opts)
 		if err != nil {
 			return err
 		}
@@ -71,7 +69,7 @@ func (e *Expression) Parse() error {
 	}
 	// Parse Then
 	if e.Then != "" {
-		execute, err := ParseExpression(e.Then)
+		execute, err := env.ParseExpression(e.Then, opts...)
 		if err != nil {
 			return err
 		}
@@ -83,7 +81,7 @@ func (e *Expression) Parse() error {
 	}
 	// Parse Otherwise
 	if e.Otherwise != "" {
-		execute, err := ParseExpression(e.Otherwise)
+		execute, err := env.ParseExpression(e.Otherwise, opts...)
 		if err != nil {
 			return err
 		}
@@ -96,11 +94,37 @@ func (e *Expression) Parse() error {
 	return nil
 }
 
+// parseCondition parses a condition with env, it must be a boolean
+func parseCondition(env *Env, cond string, opts []Option) (*FunctionCall, error) {
+	expr, err := env.ParseExpression(cond, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Without a schema variables have no known type
+	if t := expr.Type(); t != TypeBool && (t != TypeAny || expr.opts.schema != nil) {
+		perr := newParseError(cond, 0, cond, nil, fmt.Sprintf("%v: got %s", ErrConditionType, t))
+		perr.Err = ErrConditionType
+		return nil, perr
+	}
+	return expr, nil
+}
+
 func (e *Expression) Eval(vars map[string]any) (any, error) {
+	return e.EvalWith(MapResolver(vars))
+}
+
+// EvalWith evaluates the expression like Eval, fetching variables from r
+func (e *Expression) EvalWith(r Resolver) (any, error) {
+	if r == nil {
+		r = MapResolver(nil)
+	}
 	// Execute condition
 	condition := true
 	if e.ifAction != nil {
-		conditionRes := e.ifAction.execute.Execute(vars)
+		conditionRes, err := executeFunctionCall(e.ifAction.execute, r)
+		if err != nil {
+			return nil, err
+		}
 		if !cast.ToBool(conditionRes) {
 			condition = false
 		}
@@ -108,11 +132,14 @@ func (e *Expression) Eval(vars map[string]any) (any, error) {
 
 	// Execute Then
 	if condition && e.Then != "" && e.thenAction != nil {
-		return executeFunctionCall(e.thenAction.execute, vars), nil
+		return executeFunctionCall(e.thenAction.execute, r)
 	}
 	// Execute Otherwise
 	if !condition && e.Otherwise != "" && e.otherwiseAction != nil {
-		return executeFunctionCall(e.otherwiseAction.execute, vars), nil
+		return executeFunctionCall(e.otherwiseAction.execute, r)
+	}
+	if !condition && e.Otherwise == "" {
+		return nil, &NoMatchError{Cases: 1}
 	}
 	return nil, errors.New("invalid expression")
 }
@@ -1,13 +1,19 @@
 package parser
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	_ "net/http/pprof"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/expr-lang/expr"
+	"github.com/shopspring/decimal"
 	"github.com/spf13/cast"
 )
 
@@ -146,13 +152,1564 @@ func TestExecute(t *testing.T) {
 			},
 			want: int64(7),
 		},
+		{
+			name: "test3",
+			args: args{
+				expression: `@append($name,"x,(y):z")`,
+				vars:       map[string]any{"name": "a"},
+			},
+			want: "ax,(y):z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _ := ParseExpression(tt.args.expression)
+			if got, _ := executeFunctionCall(f, MapResolver(tt.args.vars)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Execute() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteE(t *testing.T) {
+	errLookup := errors.New("lookup failed")
+	RegisterErrorFunc("lookupFail", func(args ...any) (any, error) {
+		return nil, errLookup
+	})
+
+	tests := []struct {
+		name       string
+		expression string
+		vars       map[string]any
+		want       any
+		wantErr    error
+		wantExpr   string
+	}{
+		{
+			name:       "test1",
+			expression: `$a/$b`,
+			vars:       map[string]any{"a": 1, "b": 0},
+			wantErr:    ErrDivisionByZero,
+			wantExpr:   "$a/$b",
+		},
+		{
+			name:       "test2",
+			expression: `1.5/0`,
+			wantErr:    ErrDivisionByZero,
+			wantExpr:   "1.5/0",
+		},
+		{
+			name:       "test3",
+			expression: `1+$a%0`,
+			vars:       map[string]any{"a": 7},
+			wantErr:    ErrDivisionByZero,
+			wantExpr:   "$a%0",
+		},
+		{
+			name:       "test4",
+			expression: `@regexp($a,"[")`,
+			vars:       map[string]any{"a": "x"},
+			wantExpr:   `@regexp($a,"[")`,
+		},
+		{
+			name:       "test5",
+			expression: `$a % $b`,
+			vars:       map[string]any{"a": 3, "b": 0},
+			wantErr:    ErrDivisionByZero,
+			wantExpr:   "$a % $b",
+		},
+		{
+			name:       "test6",
+			expression: `@lookupFail($a)*2`,
+			wantErr:    errLookup,
+			wantExpr:   "@lookupFail($a)",
+		},
+		{
+			name:       "test7",
+			expression: `$a/$b`,
+			vars:       map[string]any{"a": 6, "b": 3},
+			want:       int64(2),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+			got, err := f.ExecuteE(tt.vars)
+			if tt.wantExpr == "" {
+				if err != nil || !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ExecuteE() = %v, %v, want %v", got, err, tt.want)
+				}
+				return
+			}
+
+			var evalErr *EvalError
+			if !errors.As(err, &evalErr) {
+				t.Fatalf("ExecuteE() error = %v, want *EvalError", err)
+			}
+			if evalErr.Expression != tt.wantExpr {
+				t.Errorf("EvalError.Expression = %q, want %q", evalErr.Expression, tt.wantExpr)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ExecuteE() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := f.Execute(tt.vars); got != nil {
+				t.Errorf("Execute() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestParseFunctionExpression(t *testing.T) {
+	vars := map[string]any{"a": 2, "name": "go"}
+	tests := []struct {
+		name       string
+		expression string
+		want       any
+		wantErr    string
+	}{
+		{name: "test1", expression: `add($a,1)`, want: int64(3)},
+		{name: "test2", expression: `multi(add($a, 1.5:float), 2)`, want: 7.0},
+		{name: "test3", expression: `and(gt($a,1),hasPrefix($name,g:str))`, want: true},
+		{name: "test4", expression: `append(hello :str,$name)`, want: "hello go"},
+		{name: "test5", expression: `$a`, want: 2},
+		{name: "test6", expression: `12`, want: int64(12)},
+		{name: "test7", expression: `nope(1)`, wantErr: "function not found: nope"},
+		{name: "test8", expression: `add(1,x:bool)`, wantErr: "invalid argument: x:bool"},
+		{name: "test9", expression: `add(1,2.5)`, wantErr: "invalid int argument: 2.5"},
+		{name: "test10", expression: `add(1,neg(2)`, wantErr: "unclosed call neg(2"},
+		{name: "test11", expression: `add(1)`, wantErr: "wrong number of arguments: add takes 2 arguments, got 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFunctionExpression(tt.expression)
+			if tt.wantErr != "" {
+				var perr *ParseError
+				if !errors.As(err, &perr) || perr.Msg != tt.wantErr {
+					t.Errorf("ParseFunctionExpression() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFunctionExpression() error = %v", err)
+			}
+			if got, err := f.ExecuteE(vars); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExecuteE() = %#v, %v, want %#v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUndefinedVariable(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		opts       []Option
+		want       any
+		wantErr    *UndefinedVariableError
+	}{
+		{
+			name:       "test1",
+			expression: `$stok`,
+			want:       "stok",
+		},
+		{
+			name:       "test2",
+			expression: `$stok`,
+			opts:       []Option{Lenient()},
+			want:       nil,
+		},
+		{
+			name:       "test3",
+			expression: `$stok>100`,
+			opts:       []Option{Strict()},
+			wantErr:    &UndefinedVariableError{Name: "stok", Pos: 0},
+		},
+		{
+			name:       "test4",
+			expression: `1 + @trimInt($stock, $prefix)`,
+			opts:       []Option{Strict()},
+			wantErr:    &UndefinedVariableError{Name: "prefix", Pos: 21},
+		},
+		{
+			name:       "test5",
+			expression: `$stock*2`,
+			opts:       []Option{Strict()},
+			want:       int64(4),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAndExecute(tt.expression, vars, tt.opts...)
+			if tt.wantErr == nil {
+				if err != nil || !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ParseAndExecute() = %v, %v, want %v", got, err, tt.want)
+				}
+				return
+			}
+
+			var undefinedErr *UndefinedVariableError
+			if !errors.As(err, &undefinedErr) {
+				t.Fatalf("ParseAndExecute() error = %v, want *UndefinedVariableError", err)
+			}
+			if *undefinedErr != *tt.wantErr {
+				t.Errorf("ParseAndExecute() error = %+v, want %+v", undefinedErr, tt.wantErr)
+			}
+		})
+	}
+}
+
+type pathCustomer struct {
+	Tier  string `json:"tier"`
+	Since int
+}
+
+type pathBase struct {
+	ID int64 `json:"id"`
+}
+
+type pathOrder struct {
+	pathBase
+	Customer *pathCustomer `json:"customer"`
+	Lines    []pathLine    `json:"lines"`
+	Counts   map[int]int
+}
+
+type pathLine struct {
+	Price float64 `json:"price,omitempty"`
+}
+
+func TestVariablePath(t *testing.T) {
+	vars := map[string]any{
+		"order": map[string]any{
+			"customer": map[string]any{"tier": "gold"},
+		},
+		"items": []any{
+			map[string]any{"price": 12.5, "qty": 2},
+		},
+		"attrs":  map[string]string{"color": "red", "main color": "blue"},
+		"typed":  &pathOrder{pathBase: pathBase{ID: 7}, Customer: &pathCustomer{Tier: "silver", Since: 2019}, Lines: []pathLine{{Price: 3}}, Counts: map[int]int{2: 5}},
+		"nilPtr": (*pathOrder)(nil),
+	}
+	tests := []struct {
+		name       string
+		expression string
+		opts       []Option
+		want       any
+		wantErr    *UndefinedVariableError
+	}{
+		{name: "test1", expression: `$order.customer.tier == "gold"`, want: true},
+		{name: "test2", expression: `$items[0].price * $items[0].qty`, want: 25.0},
+		{name: "test3", expression: `$attrs["color"]`, want: "red"},
+		{name: "test4", expression: `$attrs['main color']`, want: "blue"},
+		{name: "test5", expression: `$typed.customer.tier`, want: "silver"},
+		{name: "test6", expression: `$typed.Customer.Since + 1`, want: int64(2020)},
+		{name: "test7", expression: `$typed.lines[0].price`, want: 3.0},
+		{name: "test8", expression: `$typed.id`, want: int64(7)},
+		{name: "test9", expression: `$typed.Counts[2]`, want: 5},
+		{name: "test10", expression: `$order.customer.name`, want: "order.customer.name"},
+		{name: "test11", expression: `$items[3].price`, opts: []Option{Lenient()}, want: nil},
+		{
+			name:       "test12",
+			expression: `$order.customer.name.first`,
+			opts:       []Option{Strict()},
+			wantErr:    &UndefinedVariableError{Name: "order.customer.name.first", Missing: "order.customer.name", Pos: 0},
+		},
+		{
+			name:       "test13",
+			expression: `1 + $items[1].price`,
+			opts:       []Option{Strict()},
+			wantErr:    &UndefinedVariableError{Name: "items[1].price", Missing: "items[1]", Pos: 4},
+		},
+		{
+			name:       "test14",
+			expression: `$nilPtr.customer`,
+			opts:       []Option{Strict()},
+			wantErr:    &UndefinedVariableError{Name: "nilPtr.customer", Missing: "nilPtr.customer", Pos: 0},
+		},
+		{
+			name:       "test15",
+			expression: `$missing.customer`,
+			opts:       []Option{Strict()},
+			wantErr:    &UndefinedVariableError{Name: "missing.customer", Pos: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAndExecute(tt.expression, vars, tt.opts...)
+			if tt.wantErr == nil {
+				if err != nil || !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ParseAndExecute() = %v, %v, want %v", got, err, tt.want)
+				}
+				return
+			}
+
+			var undefinedErr *UndefinedVariableError
+			if !errors.As(err, &undefinedErr) {
+				t.Fatalf("ParseAndExecute() error = %v, want *UndefinedVariableError", err)
+			}
+			if *undefinedErr != *tt.wantErr {
+				t.Errorf("ParseAndExecute() error = %+v, want %+v", undefinedErr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecuteWith(t *testing.T) {
+	type account struct {
+		Balance int64  `json:"balance"`
+		Tier    string `json:"tier"`
+		secret  string
+	}
+	acct := &account{Balance: 250, Tier: "gold", secret: "x"}
+
+	var looked []string
+	lazy := ResolverFunc(func(name string) (any, bool) {
+		looked = append(looked, name)
+		switch name {
+		case "limit":
+			return int64(200), true
+		case "expensive":
+			return int64(1), true
+		}
+		return nil, false
+	})
+
+	tests := []struct {
+		name       string
+		expression string
+		resolver   Resolver
+		want       any
+		wantLooked []string
+	}{
+		{name: "test1", expression: `$balance > 100 && $Tier == "gold"`, resolver: StructResolver(acct), want: true},
+		{name: "test2", expression: `$secret`, resolver: StructResolver(acct), want: "secret"},
+		{name: "test3", expression: `$balance - $limit`, resolver: ChainResolver(StructResolver(acct), lazy), want: int64(50), wantLooked: []string{"limit"}},
+		{name: "test4", expression: `$limit > 100 || $expensive > 0`, resolver: lazy, want: true, wantLooked: []string{"limit"}},
+		{name: "test5", expression: `$stock + $qty`, resolver: ChainResolver(nil, MapResolver{"qty": 3}, MapResolver(vars)), want: int64(5)},
+		{name: "test6", expression: `$order.tier`, resolver: MapResolver{"order": acct}, want: "gold"},
+		{name: "test7", expression: `$balance`, resolver: nil, want: "balance"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			looked = nil
+			call, err := ParseExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+			got, err := call.ExecuteWith(tt.resolver)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExecuteWith() = %v, %v, want %v", got, err, tt.want)
+			}
+			if !reflect.DeepEqual(looked, tt.wantLooked) {
+				t.Errorf("Lookup() calls = %v, want %v", looked, tt.wantLooked)
+			}
+		})
+	}
+
+	e := &Expression{If: `$balance >= 100`, Then: `"ok"`, Otherwise: `"low"`}
+	if err := e.Parse(); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got, err := e.EvalWith(StructResolver(account{Balance: 10})); err != nil || got != "low" {
+		t.Errorf("EvalWith() = %v, %v, want low", got, err)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	RegisterFunc("isVIP", func(args ...any) any { return true })
+
+	tests := []struct {
+		name       string
+		expression string
+		wantVars   []string
+		wantFuncs  []string
+	}{
+		{
+			name:       "test1",
+			expression: `$price >= 100 && @isVIP($userId) || $price < 10`,
+			wantVars:   []string{"price", "userId"},
+			wantFuncs:  []string{"isVIP"},
+		},
+		{
+			name:       "test2",
+			expression: `@hasPrefix($order.customer.tier, "g") && $items[0].price * $order.qty > @trimInt($raw, "x")`,
+			wantVars:   []string{"items[0].price", "order.customer.tier", "order.qty", "raw"},
+			wantFuncs:  []string{"hasPrefix", "trimInt"},
+		},
+		{
+			name:       "test3",
+			expression: `$a`,
+			wantVars:   []string{"a"},
+		},
+		{
+			name:       "test4",
+			expression: `-1 + 2`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseExpression(tt.expression)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+			if got := f.Variables(); !reflect.DeepEqual(got, tt.wantVars) {
+				t.Errorf("Variables() = %v, want %v", got, tt.wantVars)
+			}
+			if got := f.Functions(); !reflect.DeepEqual(got, tt.wantFuncs) {
+				t.Errorf("Functions() = %v, want %v", got, tt.wantFuncs)
+			}
+		})
+	}
+
+	e := &Expression{If: `@isVIP($user) && $total > 100`, Then: `$total * 0.9`, Otherwise: `@trimInt($total, "$")`}
+	if err := e.Parse(); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got, want := e.Variables(), []string{"total", "user"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expression.Variables() = %v, want %v", got, want)
+	}
+	if got, want := e.Functions(), []string{"isVIP", "trimInt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expression.Functions() = %v, want %v", got, want)
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	env := NewEnv()
+	var pureCalls, impureCalls int
+	env.RegisterFunc("double", func(args ...any) any {
+		pureCalls++
+		return cast.ToInt64(args[0]) * 2
+	}, Pure())
+	env.RegisterFunc("now", func(args ...any) any {
+		impureCalls++
+		return int64(1)
+	})
+
+	f, err := env.ParseExpression(`3*(1+2)`)
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	if !f.isConst() || f.Const != int64(9) || f.Expression != "multi(3,add(1,2))" {
+		t.Errorf("ParseExpression() = %+v, want constant 9", f)
+	}
+
+	f, err = env.ParseExpression(`$a * (2+3) > @double(2) + @now()`)
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	left, right := f.Args[0].FunctionCall, f.Args[1].FunctionCall
+	if left.Args[1].FunctionCall != nil || left.Args[1].Const != int64(5) {
+		t.Errorf("Args = %+v, want 2+3 folded", left.Args[1])
+	}
+	if right.Args[0].FunctionCall != nil || right.Args[0].Const != int64(4) {
+		t.Errorf("Args = %+v, want @double(2) folded", right.Args[0])
+	}
+	if right.Args[1].FunctionCall == nil {
+		t.Errorf("Args = %+v, want @now() kept", right.Args[1])
+	}
+	for range 2 {
+		if got, err := f.ExecuteE(map[string]any{"a": 1}); err != nil || got != false {
+			t.Errorf("ExecuteE() = %v, %v, want false", got, err)
+		}
+	}
+	if pureCalls != 1 || impureCalls != 2 {
+		t.Errorf("calls = %d pure, %d impure, want 1 and 2", pureCalls, impureCalls)
+	}
+	if got, want := f.Functions(), []string{"double", "now"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Functions() = %v, want %v", got, want)
+	}
+
+	// Failing calls are left for execution to report
+	f, err = env.ParseExpression(`1 + 4/0`)
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	if _, err := f.ExecuteE(nil); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("ExecuteE() error = %v, want %v", err, ErrDivisionByZero)
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	calls := 0
+	RegisterFunc("expensiveLookup", func(args ...any) any {
+		calls++
+		return true
+	})
+
+	tests := []struct {
+		name       string
+		expression string
+		vars       map[string]any
+		want       any
+		wantCalls  int
+	}{
+		{
+			name:       "test1",
+			expression: `$user != "" && @expensiveLookup($user)`,
+			vars:       map[string]any{"user": ""},
+			want:       false,
+			wantCalls:  0,
+		},
+		{
+			name:       "test2",
+			expression: `$user != "" && @expensiveLookup($user)`,
+			vars:       map[string]any{"user": "u1"},
+			want:       true,
+			wantCalls:  1,
+		},
+		{
+			name:       "test3",
+			expression: `$user == "" || @expensiveLookup($user)`,
+			vars:       map[string]any{"user": ""},
+			want:       true,
+			wantCalls:  0,
+		},
+		{
+			name:       "test4",
+			expression: `$d != 0 && $n/$d > 1`,
+			vars:       map[string]any{"n": 10, "d": 0},
+			want:       false,
+		},
+		{
+			name:       "test5",
+			expression: `!($d != 0 && $n/$d > 1)`,
+			vars:       map[string]any{"n": 10, "d": 5},
+			want:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			got, err := ParseAndExecute(tt.expression, tt.vars)
+			if err != nil || got != tt.want {
+				t.Errorf("ParseAndExecute() = %v, %v, want %v", got, err, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("expensiveLookup called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestEnv(t *testing.T) {
+	tenantA := NewEnv()
+	tenantA.RegisterFunc("tier", func(args ...any) any { return "gold" })
+	tenantB := tenantA.Clone()
+	tenantB.RegisterFunc("tier", func(args ...any) any { return "silver" })
+	tenantB.SetDecimalsPlace(1)
+
+	if got, err := tenantA.ParseAndExecute(`@tier()`, nil); err != nil || got != "gold" {
+		t.Errorf("tenantA @tier() = %v, %v, want gold", got, err)
+	}
+	if got, err := tenantB.ParseAndExecute(`@tier()`, nil); err != nil || got != "silver" {
+		t.Errorf("tenantB @tier() = %v, %v, want silver", got, err)
+	}
+	if _, err := ParseExpression(`@tier()`); err == nil {
+		t.Errorf("ParseExpression(@tier()) on default Env succeeded, want function not found")
+	}
+
+	// Functions may keep their arguments
+	tenantA.RegisterFunc("list", func(args ...any) any { return args })
+	list, err := tenantA.ParseExpression(`@list(1, "a", $x)`)
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	first := list.Execute(map[string]any{"x": true})
+	if got := list.Execute(map[string]any{"x": false}); !reflect.DeepEqual(first, []any{int64(1), "a", true}) || !reflect.DeepEqual(got, []any{int64(1), "a", false}) {
+		t.Errorf("@list(1, \"a\", $x) = %v then %v, want [1 a true] then [1 a false]", first, got)
+	}
+
+	if got, err := tenantA.ParseAndExecute(`1.26*1`, nil); err != nil || got != 1.26 {
+		t.Errorf("tenantA 1.26*1 = %v, %v, want 1.26", got, err)
+	}
+	if got, err := tenantB.ParseAndExecute(`1.26*1`, nil); err != nil || got != 1.3 {
+		t.Errorf("tenantB 1.26*1 = %v, %v, want 1.3", got, err)
+	}
+	if got, err := tenantB.ParseAndExecute(`1.26*1`, nil, WithDecimalsPlace(2)); err != nil || got != 1.26 {
+		t.Errorf("tenantB 1.26*1 with 2 places = %v, %v, want 1.26", got, err)
+	}
+
+	// The package level setting also applies to expressions already parsed
+	parsed, err := ParseExpression(`$a*1 + $a*1`)
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	fixed, err := ParseExpression(`$a*1`, WithDecimalsPlace(2))
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	SetDecimalsPlace(1)
+	defer SetDecimalsPlace(6)
+	vars := map[string]any{"a": 1.26}
+	if got, err := parsed.ExecuteE(vars); err != nil || got != 2.6 {
+		t.Errorf("$a*1 + $a*1 after SetDecimalsPlace(1) = %v, %v, want 2.6", got, err)
+	}
+	clone := DefaultEnv().Clone()
+	SetDecimalsPlace(2)
+	if got, err := clone.ParseAndExecute(`1.0/3`, nil); err != nil || got != 0.3 {
+		t.Errorf("1.0/3 on a clone of the default Env = %v, %v, want 0.3", got, err)
+	}
+	if got, err := fixed.ExecuteE(vars); err != nil || got != 1.26 {
+		t.Errorf("$a*1 with 2 places after SetDecimalsPlace(1) = %v, %v, want 1.26", got, err)
+	}
+
+	// Registering while parsing must not race
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			tenantA.RegisterFunc("echo", func(args ...any) any { return args[0] })
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = tenantA.ParseExpression(`@tier() + $a`)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCompiledOperators(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		vars       map[string]any
+		want       any
+	}{
+		{name: "test1", expression: `$a+$b`, vars: map[string]any{"a": 1, "b": int8(2)}, want: int64(3)},
+		{name: "test2", expression: `$a+$b`, vars: map[string]any{"a": 1, "b": 0.25}, want: 1.25},
+		{name: "test3", expression: `$a*$b`, vars: map[string]any{"a": "1.5", "b": "2"}, want: 3.0},
+		{name: "test4", expression: `$a-$b`, vars: map[string]any{"a": "7", "b": uint(2)}, want: int64(5)},
+		{name: "test5", expression: `$a>$b`, vars: map[string]any{"a": 2, "b": 1.5}, want: true},
+		{name: "test6", expression: `$a<=$b`, vars: map[string]any{"a": "10", "b": 9}, want: false},
+		{name: "test7", expression: `$a==$b`, vars: map[string]any{"a": 2, "b": 2.0}, want: true},
+		{name: "test8", expression: `$a!=$b`, vars: map[string]any{"a": int32(5), "b": int64(5)}, want: false},
+		{name: "test9", expression: `$a/$b`, vars: map[string]any{"a": 7, "b": 2}, want: int64(3)},
+		{name: "test10", expression: `$a%$b`, vars: map[string]any{"a": 7, "b": 2.0}, want: 1.0},
+		{name: "test11", expression: `$a`, vars: map[string]any{"a": 300}, want: 300},
+		{name: "test12", expression: `5.5%$b`, vars: map[string]any{"b": 2}, want: 1.5},
+		{name: "test13", expression: `$a%$b`, vars: map[string]any{"a": -7.5, "b": 2}, want: -1.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAndExecute(tt.expression, tt.vars)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAndExecute() = %#v, %v, want %#v", got, err, tt.want)
+			}
+		})
+	}
+
+	// Trees built by hand are compiled when executed
+	f := &FunctionCall{
+		FunctionName: "append",
+		Function:     func(args ...any) any { return cast.ToString(args[0]) + cast.ToString(args[1]) },
+		Args:         []*FunctionArg{{Variable: "a"}, {Const: "!"}},
+	}
+	if got := f.Execute(map[string]any{"a": "hi"}); got != "hi!" {
+		t.Errorf("Execute() = %v, want hi!", got)
+	}
+
+	// and may run in several goroutines
+	g := &FunctionCall{FunctionName: f.FunctionName, Function: f.Function, Args: f.Args}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := g.Execute(map[string]any{"a": "go"}); got != "go!" {
+				t.Errorf("Execute() = %v, want go!", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestExecuteNumericAllocs(t *testing.T) {
+	f, err := ParseExpression(numericExpression)
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	if got := f.Execute(numericVars); got != true {
+		t.Fatalf("Execute() = %v, want true", got)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		f.Execute(numericVars)
+	})
+	if allocs != 0 {
+		t.Errorf("Execute() allocs = %v, want 0", allocs)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantLine   int
+		wantColumn int
+		wantToken  string
+		wantPretty string
+	}{
+		{
+			name:       "test1",
+			expression: `$price > * 2`,
+			wantLine:   1,
+			wantColumn: 10,
+			wantToken:  "*",
+			wantPretty: "$price > * 2\n         ^ expected operand, found \"*\"",
+		},
+		{
+			name:       "test2",
+			expression: "$a > 1 &&\n\t@nope($a)",
+			wantLine:   2,
+			wantColumn: 2,
+			wantToken:  "nope",
+			wantPretty: "line 2:\n\t@nope($a)\n\t^ function not found: nope",
+		},
+		{
+			name:       "test3",
+			expression: `"é" == "e" )`,
+			wantLine:   1,
+			wantColumn: 12,
+			wantToken:  ")",
+			wantPretty: "\"é\" == \"e\" )\n           ^ expected operator, found \")\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseExpression(tt.expression)
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("ParseExpression() error = %v, want *ParseError", err)
+			}
+			if perr.Line != tt.wantLine || perr.Column != tt.wantColumn || perr.Token != tt.wantToken {
+				t.Errorf("ParseError = %d:%d %q, want %d:%d %q", perr.Line, perr.Column, perr.Token, tt.wantLine, tt.wantColumn, tt.wantToken)
+			}
+			if got := perr.Pretty(); got != tt.wantPretty {
+				t.Errorf("Pretty() =\n%s\nwant\n%s", got, tt.wantPretty)
+			}
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	env := NewEnv()
+	env.RegisterFunc("clamp", func(args ...any) any {
+		return args[0]
+	}, WithSignature(Signature{
+		Params:   []Param{{"x", TypeNumber}, {"lo", TypeNumber}, {"hi", TypeNumber}},
+		Optional: 1,
+		Returns:  TypeNumber,
+	}))
+	env.RegisterFunc("concat", func(args ...any) any {
+		return fmt.Sprint(args...)
+	}, WithSignature(Signature{
+		Params:   []Param{{"sep", TypeString}, {"parts", TypeString}},
+		Variadic: true,
+		Returns:  TypeString,
+	}))
+	env.RegisterFunc("any", func(args ...any) any {
+		return len(args)
+	}, WithSignature(Signature{Variadic: true}))
+
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    error
+		wantColumn int
+		wantMsg    string
+	}{
+		{name: "test1", expression: `@trim($x)`, wantErr: ErrArgumentCount, wantColumn: 1, wantMsg: "wrong number of arguments: trim takes 2 arguments, got 1"},
+		{name: "test2", expression: `@add("a")`, wantErr: ErrArgumentCount, wantColumn: 1, wantMsg: "wrong number of arguments: add takes 2 arguments, got 1"},
+		{name: "test3", expression: `1 + @clamp($x)`, wantErr: ErrArgumentCount, wantColumn: 5, wantMsg: "wrong number of arguments: clamp takes 2 to 3 arguments, got 1"},
+		{name: "test4", expression: `@clamp($x, 0, "max")`, wantErr: ErrArgumentType, wantColumn: 15, wantMsg: "wrong argument type: argument 3 (hi) of clamp must be number, got string"},
+		{name: "test5", expression: `@clamp($x, true)`, wantErr: ErrArgumentType, wantColumn: 12, wantMsg: "wrong argument type: argument 2 (lo) of clamp must be number, got bool"},
+		{name: "test6", expression: `$a > @hasPrefix($b, "x")`, wantErr: ErrArgumentType, wantColumn: 6, wantMsg: "wrong argument type: argument 2 (b) of gt must be number, got bool"},
+		{name: "test7", expression: `@concat()`, wantErr: ErrArgumentCount, wantColumn: 1, wantMsg: "wrong number of arguments: concat takes at least 1 argument, got 0"},
+		{name: "test8", expression: `"a" % 2`, wantErr: ErrArgumentType, wantColumn: 1, wantMsg: "wrong argument type: argument 1 (a) of mod must be number, got string"},
+		{name: "test9", expression: `@clamp($x, "1", 2.5) + @concat("-", 1, $a, true)`},
+		{name: "test10", expression: `@trimInt($s, "x") % 2 == 1 && @clamp($x + 1, 0)`},
+		{name: "test11", expression: `@any() + @any(1, "a", true)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.ParseExpression(tt.expression)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("ParseExpression() error = %v", err)
+				}
+				return
+			}
+
+			var perr *ParseError
+			if !errors.As(err, &perr) || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseExpression() error = %v, want *ParseError wrapping %v", err, tt.wantErr)
+			}
+			if perr.Column != tt.wantColumn || perr.Msg != tt.wantMsg {
+				t.Errorf("ParseError = %d: %s, want %d: %s", perr.Column, perr.Msg, tt.wantColumn, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestRegisterGoFunc(t *testing.T) {
+	type celsius float64
+	errNegative := errors.New("negative count")
+
+	env := NewEnv()
+	funcs := map[string]any{
+		"repeat": func(s string, n int) (string, error) {
+			if n < 0 {
+				return "", errNegative
+			}
+			return strings.Repeat(s, n), nil
+		},
+		"toF": func(c celsius) float64 { return float64(c)*9/5 + 32 },
+		"sum": func(base int64, xs ...uint8) int64 {
+			s := base
+			for _, x := range xs {
+				s += int64(x)
+			}
+			return s
+		},
+		"isNil": func(v any) bool { return v == nil },
+		"keys":  func(m map[string]any) int { return len(m) },
+		"check": func(ok bool) error {
+			if !ok {
+				return errNegative
+			}
+			return nil
+		},
+	}
+	for name, fn := range funcs {
+		if err := env.RegisterGoFunc(name, fn, Pure()); err != nil {
+			t.Fatalf("RegisterGoFunc(%s) error = %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		vars       map[string]any
+		want       any
+		wantErr    error
+	}{
+		{name: "test1", expression: `@repeat($s, $n)`, vars: map[string]any{"s": "ab", "n": "3"}, want: "ababab"},
+		{name: "test2", expression: `@repeat("x", $n)`, vars: map[string]any{"n": -1}, wantErr: errNegative},
+		{name: "test3", expression: `@repeat("x", $n)`, vars: map[string]any{"n": "many"}, wantErr: ErrArgumentType},
+		{name: "test4", expression: `@toF($c)`, vars: map[string]any{"c": 100}, want: 212.0},
+		{name: "test5", expression: `@sum(1) + @sum(1, 2, $x)`, vars: map[string]any{"x": "3"}, want: int64(7)},
+		{name: "test6", expression: `@sum(1, $x)`, vars: map[string]any{"x": 300}, wantErr: ErrArgumentType},
+		{name: "test7", expression: `@isNil($missing) && !@isNil($m)`, vars: map[string]any{"missing": nil, "m": 1}, want: true},
+		{name: "test8", expression: `@keys($m)`, vars: map[string]any{"m": map[string]any{"a": 1}}, want: 1},
+		{name: "test9", expression: `@keys($m)`, vars: map[string]any{"m": 1}, wantErr: ErrArgumentType},
+		{name: "test10", expression: `@check($ok)`, vars: map[string]any{"ok": true}, want: nil},
+		{name: "test11", expression: `@check(false)`, wantErr: errNegative},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := env.ParseAndExecute(tt.expression, tt.vars)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ParseAndExecute() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAndExecute() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	// The derived signature is checked at parse time
+	for _, expr := range []string{`@repeat("x")`, `@repeat("x", 1.5)`, `@toF("hot")`} {
+		if _, err := env.ParseExpression(expr); !errors.As(err, new(*ParseError)) {
+			t.Errorf("ParseExpression(%s) error = %v, want *ParseError", expr, err)
+		}
+	}
+	for _, fn := range []any{nil, 42, (func())(nil), func() (int, int) { return 0, 0 }} {
+		if err := env.RegisterGoFunc("bad", fn); err == nil {
+			t.Errorf("RegisterGoFunc(%T) error = nil", fn)
+		}
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	schema := Schema{
+		"price":       TypeFloat,
+		"qty":         TypeInt,
+		"name":        TypeString,
+		"vip":         TypeBool,
+		"order":       TypeAny,
+		"customer.id": TypeInt,
 	}
 
+	tests := []struct {
+		name       string
+		expression string
+		want       Type
+		wantErr    error
+		wantColumn int
+	}{
+		{name: "test1", expression: `$price * $qty > 100 && !$vip`, want: TypeBool},
+		{name: "test2", expression: `$qty * 2 - 1`, want: TypeInt},
+		{name: "test3", expression: `$price / $qty`, want: TypeFloat},
+		{name: "test4", expression: `@append($name, "x")`, want: TypeString},
+		{name: "test5", expression: `$order.lines[0].sku`, want: TypeAny},
+		{name: "test6", expression: `$customer.id % 2 == 0`, want: TypeBool},
+		{name: "test7", expression: `$price > "abc"`, wantErr: ErrArgumentType, wantColumn: 10},
+		{name: "test8", expression: `@hasPrefix(1, 2)`, wantErr: ErrArgumentType, wantColumn: 12},
+		{name: "test9", expression: `$name + 1`, wantErr: ErrArgumentType, wantColumn: 1},
+		{name: "test10", expression: `$qty > 1 && $name`, wantErr: ErrArgumentType, wantColumn: 13},
+		{name: "test11", expression: `$price == $name`, wantErr: ErrArgumentType, wantColumn: 1},
+		{name: "test12", expression: `$qty > 1 || $stock > 1`, wantErr: ErrUndeclaredVariable, wantColumn: 13},
+		{name: "test13", expression: `@trim($customer.name, " ")`, wantErr: ErrUndeclaredVariable, wantColumn: 7},
+		{name: "test14", expression: `$qty % 1.5`, want: TypeFloat},
+		{name: "test15", expression: `$qty % $name`, wantErr: ErrArgumentType, wantColumn: 8},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			f, _ := ParseExpression(tt.args.expression)
-			if got := executeFunctionCall(f, tt.args.vars); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Execute() = %v, want %v", got, tt.want)
+			f, err := ParseExpression(tt.expression, WithSchema(schema))
+			if tt.wantErr == nil {
+				if err != nil || f.Type() != tt.want {
+					t.Errorf("ParseExpression() = %v, %v, want type %v", f, err, tt.want)
+				}
+				return
+			}
+
+			var perr *ParseError
+			if !errors.As(err, &perr) || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseExpression() error = %v, want *ParseError wrapping %v", err, tt.wantErr)
+			}
+			if perr.Column != tt.wantColumn {
+				t.Errorf("ParseError.Column = %d, want %d: %v", perr.Column, tt.wantColumn, err)
+			}
+		})
+	}
+
+	// Without a schema operands are converted at run time as before
+	if f, err := ParseExpression(`@hasPrefix(1, 2) || $x`); err != nil || f.Type() != TypeBool {
+		t.Errorf("ParseExpression() = %v, %v, want type bool", f, err)
+	}
+
+	conditions := []struct {
+		cond    string
+		opts    []Option
+		wantErr bool
+	}{
+		{cond: `$qty > 1`, opts: []Option{WithSchema(schema)}},
+		{cond: `$vip`, opts: []Option{WithSchema(schema)}},
+		{cond: `$qty`, opts: []Option{WithSchema(schema)}, wantErr: true},
+		{cond: `$order.paid`, opts: []Option{WithSchema(schema)}, wantErr: true},
+		{cond: `$paid`},
+		{cond: `$qty + 1`, wantErr: true},
+	}
+	for _, c := range conditions {
+		e := &Expression{If: c.cond, Then: `1`}
+		err := e.Parse(c.opts...)
+		if gotErr := errors.Is(err, ErrConditionType); gotErr != c.wantErr {
+			t.Errorf("Parse(If: %s) error = %v, want condition error %v", c.cond, err, c.wantErr)
+		}
+	}
+}
+
+func TestDecimalMode(t *testing.T) {
+	vars := map[string]any{"a": 1.0000001, "b": 1.0000001, "one": 1, "price": 33.5, "qty": 3, "code": "abc"}
+	tests := []struct {
+		name       string
+		expression string
+		opts       []Option
+		want       string
+	}{
+		{name: "test1", expression: `$a + $b`, want: "2"},
+		{name: "test2", expression: `$a + $b`, opts: []Option{Decimal()}, want: "2.0000002"},
+		{name: "test3", expression: `0.1 + 0.2 == 0.3`, opts: []Option{Decimal()}, want: "true"},
+		{name: "test4", expression: `7 / 2`, want: "3"},
+		{name: "test5", expression: `7 / 2`, opts: []Option{Decimal()}, want: "3.5"},
+		{name: "test6", expression: `10000000000000001.5 + $one`, opts: []Option{Decimal()}, want: "10000000000000002.5"},
+		{name: "test7", expression: `$price * $qty >= 100.5 && $qty == 3`, opts: []Option{Decimal()}, want: "true"},
+		{name: "test8", expression: `$price * $qty > 100.5`, opts: []Option{Decimal()}, want: "false"},
+		{name: "test9", expression: `-($one + 0.25) % 1`, opts: []Option{Decimal()}, want: "-0.25"},
+		{name: "test10", expression: `$one / 8`, opts: []Option{Decimal(), WithDecimalsPlace(2)}, want: "0.13"},
+		{name: "test11", expression: `$one / 8`, opts: []Option{Decimal(), WithDecimalsPlace(2), WithRounding(RoundHalfEven)}, want: "0.12"},
+		{name: "test12", expression: `-$one / 8`, opts: []Option{Decimal(), WithDecimalsPlace(2), WithRounding(RoundFloor)}, want: "-0.13"},
+		{name: "test13", expression: `-$one / 8`, opts: []Option{Decimal(), WithDecimalsPlace(2), WithRounding(RoundCeil)}, want: "-0.12"},
+		{name: "test14", expression: `2 / 3`, opts: []Option{Decimal(), WithDecimalsPlace(3), WithRounding(RoundTruncate)}, want: "0.666"},
+		{name: "test15", expression: `0.125 * $one`, opts: []Option{WithDecimalsPlace(2), WithRounding(RoundHalfEven)}, want: "0.12"},
+		{name: "test16", expression: `0.125 * $one`, opts: []Option{WithDecimalsPlace(2)}, want: "0.13"},
+		{name: "test17", expression: `- 1.5 * 2`, opts: []Option{Decimal()}, want: "-3"},
+		{name: "test18", expression: `$price % $qty`, opts: []Option{Decimal()}, want: "0.5"},
+		{name: "test19", expression: `$qty % 2 + $one`, opts: []Option{Decimal()}, want: "2"},
+		{name: "test20", expression: `$code == 0 || $code == "0"`, opts: []Option{Decimal()}, want: "false"},
+		{name: "test21", expression: `$code != 0 && $code == "abc"`, opts: []Option{Decimal()}, want: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAndExecute(tt.expression, vars, tt.opts...)
+			if err != nil {
+				t.Fatalf("ParseAndExecute() error = %v", err)
+			}
+			if s := fmt.Sprint(got); s != tt.want {
+				t.Errorf("ParseAndExecute() = %s, want %s", s, tt.want)
+			}
+		})
+	}
+
+	got, err := ParseAndExecute(`$price * 2`, vars, Decimal())
+	if d, ok := got.(decimal.Decimal); err != nil || !ok || d.InexactFloat64() != 67 {
+		t.Errorf("ParseAndExecute() = %#v, %v, want decimal 67", got, err)
+	}
+	got, err = ParseAndExecute(`$qty % 2`, vars, Decimal())
+	if d, ok := got.(decimal.Decimal); err != nil || !ok || d.InexactFloat64() != 1 {
+		t.Errorf("ParseAndExecute() = %#v, %v, want decimal 1", got, err)
+	}
+	if _, err := ParseAndExecute(`$code > 0`, vars, Decimal()); !errors.Is(err, ErrArgumentType) {
+		t.Errorf("ParseAndExecute($code > 0) error = %v, want ErrArgumentType", err)
+	}
+}
+
+// TestPrecedence checks the precedence and associativity of the operators
+// against expr-lang, variables are written without $ for it
+func TestPrecedence(t *testing.T) {
+	env := map[string]any{"a": 7, "b": 3, "c": 2, "t": true, "f": false, "n": nil}
+	tests := []struct {
+		name       string
+		expression string
+	}{
+		{name: "test1", expression: `$a + $b * $c`},
+		{name: "test2", expression: `$a * $b + $c`},
+		{name: "test3", expression: `$a - $b - $c`},
+		{name: "test4", expression: `$a - $b + $c`},
+		{name: "test5", expression: `$a * $b % $c`},
+		{name: "test6", expression: `$a % $b * $c`},
+		{name: "test7", expression: `2 * $a + 1`},
+		{name: "test8", expression: `-$a * $b`},
+		{name: "test9", expression: `-($a - $b) * $c`},
+		{name: "test10", expression: `$a - -$b`},
+		{name: "test11", expression: `$a + $b > $c * 4`},
+		{name: "test12", expression: `$a > $b == $t`},
+		{name: "test13", expression: `($a) > ($b) == true`},
+		{name: "test14", expression: `$a == $b + 4 != $f`},
+		{name: "test15", expression: `$t || $f && $f`},
+		{name: "test16", expression: `($t || $f) && $f`},
+		{name: "test17", expression: `$f && $f || $t`},
+		{name: "test18", expression: `!$f && $f`},
+		{name: "test19", expression: `!$t == $f`},
+		{name: "test20", expression: `!($a > $b) || $c >= 2 && $a < 10`},
+		{name: "test21", expression: `$a + $b * $c - $a % $b >= $c * $c || $f`},
+		{name: "test22", expression: `$a * ($b + $c) * 2 <= 70 && !$f`},
+		{name: "test23", expression: `$t ? $a : $b`},
+		{name: "test24", expression: `$f ? 1 : $t ? 2 : 3`},
+		{name: "test25", expression: `$a > $b ? $a - $b : $b - $a`},
+		{name: "test26", expression: `$t ? $f ? 1 : 2 : 3`},
+		{name: "test27", expression: `$f || $t ? $c * 2 : 0`},
+		{name: "test28", expression: `$n ?? $a`},
+		{name: "test29", expression: `($n ?? $a) * 2`},
+		{name: "test30", expression: `$n ?? $n ?? $c`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAndExecute(tt.expression, env)
+			if err != nil {
+				t.Fatalf("ParseAndExecute() error = %v", err)
+			}
+			want, err := expr.Eval(strings.ReplaceAll(tt.expression, "$", ""), env)
+			if err != nil {
+				t.Fatalf("expr.Eval() error = %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("ParseAndExecute() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestConditionalOperators(t *testing.T) {
+	calls := 0
+	env := NewEnv()
+	env.RegisterErrorFunc("fail", func(args ...any) (any, error) {
+		calls++
+		return nil, errors.New("evaluated")
+	})
+	vars := map[string]any{"vip": true, "total": 120, "discount": nil, "rate": 0.1}
+	tests := []struct {
+		name       string
+		expression string
+		opts       []Option
+		want       any
+	}{
+		{name: "test1", expression: `$vip ? $total * 0.9 : $total`, want: 108.0},
+		{name: "test2", expression: `!$vip ? @fail() : $total`, want: 120},
+		{name: "test3", expression: `$vip ? $total : @fail()`, want: 120},
+		{name: "test4", expression: `$total > 100 ? "high" : $total > 50 ? "mid" : "low"`, want: "high"},
+		{name: "test5", expression: `$discount ?? 5`, want: int64(5)},
+		{name: "test6", expression: `$rate ?? @fail()`, want: 0.1},
+		{name: "test7", expression: `$missing ?? 0`, want: int64(0)},
+		{name: "test8", expression: `$missing ?? 0`, opts: []Option{Strict()}, want: int64(0)},
+		{name: "test9", expression: `$order.coupon ?? "none"`, opts: []Option{Strict()}, want: "none"},
+		{name: "test10", expression: `$total - ($discount ?? 20)`, want: int64(100)},
+		{name: "test11", expression: `@coalesce($discount, null, 3)`, want: int64(3)},
+		{name: "test12", expression: `@if($vip, 1, 2)`, want: int64(1)},
+		{name: "test13", expression: `true ? 1 : 2`, want: int64(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := env.ParseAndExecute(tt.expression, vars, tt.opts...)
+			if err != nil {
+				t.Fatalf("ParseAndExecute() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAndExecute() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	if calls != 0 {
+		t.Errorf("fail called %d times, want 0", calls)
+	}
+
+	if _, err := env.ParseAndExecute(`$vip ? @fail() : 0`, vars); err == nil || calls != 1 {
+		t.Errorf("ParseAndExecute() error = %v, calls = %d, want error and 1 call", err, calls)
+	}
+	if _, err := env.ParseAndExecute(`$missing ? 1 : 2`, vars, Strict()); err == nil {
+		t.Error("ParseAndExecute() error = nil, want undefined variable")
+	}
+
+	schema := Schema{"vip": TypeBool, "total": TypeInt, "rate": TypeFloat, "name": TypeString}
+	f, err := ParseExpression(`$vip ? $total : $rate`, WithSchema(schema))
+	if err != nil || f.Type() != TypeNumber {
+		t.Errorf("Type() = %v, %v, want number", f, err)
+	}
+	if _, err := ParseExpression(`$total ? 1 : 2`, WithSchema(schema)); !errors.Is(err, ErrArgumentType) {
+		t.Errorf("ParseExpression() error = %v, want ErrArgumentType", err)
+	}
+	f, err = ParseExpression(`$vip ? $name : "x" ?? "y"`)
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	if got := f.Variables(); !reflect.DeepEqual(got, []string{"name", "vip"}) {
+		t.Errorf("Variables() = %v, want [name vip]", got)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	const tiers = `{
+		"cases": [
+			{"when": "$qty >= 100", "then": "$price * 0.8"},
+			{"when": "$qty >= 10", "then": "$price * 0.9"}
+		],
+		"default": "$price"
+	}`
+	var sw Switch
+	if err := json.Unmarshal([]byte(tiers), &sw); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if err := sw.Parse(); err != nil {
+		t.Fatalf("Switch.Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		vars map[string]any
+		want any
+	}{
+		{name: "test1", vars: map[string]any{"qty": 150, "price": 10}, want: 8.0},
+		{name: "test2", vars: map[string]any{"qty": 100, "price": 10}, want: 8.0},
+		{name: "test3", vars: map[string]any{"qty": 20, "price": 10}, want: 9.0},
+		{name: "test4", vars: map[string]any{"qty": 2, "price": 10}, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sw.Eval(tt.vars)
+			if err != nil {
+				t.Fatalf("Switch.Eval() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Switch.Eval() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	data, err := json.Marshal(&sw)
+	if err != nil || !strings.Contains(string(data), `"when":"$qty \u003e= 100"`) || !strings.Contains(string(data), `"default":"$price"`) {
+		t.Errorf("json.Marshal() = %s, %v", data, err)
+	}
+	if got, want := sw.Variables(), []string{"price", "qty"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Switch.Variables() = %v, want %v", got, want)
+	}
+
+	noDefault := Switch{Cases: []Case{{When: `$qty > 10`, Then: `1`}, {When: `$qty > 5`, Then: `2`}}}
+	if err := noDefault.Parse(); err != nil {
+		t.Fatalf("Switch.Parse() error = %v", err)
+	}
+	_, err = noDefault.Eval(map[string]any{"qty": 1})
+	var noMatch *NoMatchError
+	if !errors.As(err, &noMatch) || noMatch.Cases != 2 {
+		t.Errorf("Switch.Eval() error = %v, want *NoMatchError for 2 cases", err)
+	}
+
+	bad := Switch{Cases: []Case{{When: `$qty > 10`, Then: `1`}, {When: `$qty >`, Then: `2`}}}
+	var perr *ParseError
+	if err := bad.Parse(); !errors.As(err, &perr) || !strings.HasPrefix(err.Error(), "case 2: ") {
+		t.Errorf("Switch.Parse() error = %v, want *ParseError in case 2", err)
+	}
+	bad = Switch{Cases: []Case{{When: `$qty + 1`, Then: `1`}}}
+	if err := bad.Parse(WithSchema(Schema{"qty": TypeInt})); !errors.Is(err, ErrConditionType) {
+		t.Errorf("Switch.Parse() error = %v, want ErrConditionType", err)
+	}
+
+	e := &Expression{If: `$qty > 10`, Then: `1`}
+	if err := e.Parse(); err != nil {
+		t.Fatalf("Expression.Parse() error = %v", err)
+	}
+	if _, err := e.Eval(map[string]any{"qty": 1}); !errors.As(err, &noMatch) {
+		t.Errorf("Expression.Eval() error = %v, want *NoMatchError", err)
+	}
+}
+
+func TestRuleSet(t *testing.T) {
+	const rules = `{"rules": [
+		{"name": "base", "then": "$price * 0.02"},
+		{"name": "gold", "priority": 10, "tags": ["loyalty"], "if": "$tier == \"gold\"", "then": "$price * 0.1"},
+		{"name": "bulk", "priority": 5, "tags": ["volume"], "if": "$qty >= 10", "then": "$qty * 1.5"},
+		{"name": "summer", "priority": 5, "tags": ["season"], "if": "$month >= 6 && $month <= 8", "then": "5", "enabled": false},
+		{"name": "new", "tags": ["loyalty"], "if": "$orders == 0", "then": "10", "otherwise": "0"}
+	]}`
+	rs, err := LoadRuleSet([]byte(rules))
+	if err != nil {
+		t.Fatalf("LoadRuleSet() error = %v", err)
+	}
+
+	vars := map[string]any{"price": 200, "tier": "gold", "qty": 12, "month": 7, "orders": 3}
+	tests := []struct {
+		name      string
+		strategy  Strategy
+		tags      []string
+		want      any
+		wantFired []string
+	}{
+		{name: "test1", strategy: FirstMatch, want: 20.0, wantFired: []string{"gold"}},
+		{name: "test2", strategy: AllMatches, want: []any{20.0, 18.0, 4.0, int64(0)}, wantFired: []string{"gold", "bulk", "base", "new"}},
+		{name: "test3", strategy: Sum, want: 42.0, wantFired: []string{"gold", "bulk", "base", "new"}},
+		{name: "test4", strategy: Min, want: int64(0), wantFired: []string{"gold", "bulk", "base", "new"}},
+		{name: "test5", strategy: Max, want: 20.0, wantFired: []string{"gold", "bulk", "base", "new"}},
+		{name: "test6", strategy: Sum, tags: []string{"loyalty"}, want: 20.0, wantFired: []string{"gold", "new"}},
+		{name: "test7", strategy: FirstMatch, tags: []string{"season"}, want: nil, wantFired: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := rs
+			if tt.tags != nil {
+				set = rs.Tagged(tt.tags...)
+			}
+			res, err := set.Eval(vars, tt.strategy)
+			if err != nil {
+				t.Fatalf("RuleSet.Eval() error = %v", err)
+			}
+			if !reflect.DeepEqual(res.Value, tt.want) {
+				t.Errorf("RuleSet.Eval() = %#v, want %#v", res.Value, tt.want)
+			}
+			var fired []string
+			for _, f := range res.Fired {
+				fired = append(fired, f.Rule)
+			}
+			if !reflect.DeepEqual(fired, tt.wantFired) {
+				t.Errorf("RuleSet.Eval() fired %v, want %v", fired, tt.wantFired)
+			}
+		})
+	}
+
+	if got, want := rs.Variables(), []string{"month", "orders", "price", "qty", "tier"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RuleSet.Variables() = %v, want %v", got, want)
+	}
+
+	_, err = LoadRuleSet([]byte(`{"rules": [
+		{"name": "a", "if": "$x >", "then": "1"},
+		{"name": "b", "then": "@nope()"},
+		{"name": "a", "then": "1"},
+		{"then": "1"}
+	]}`))
+	for _, want := range []string{`rule "a": parse "$x >"`, `rule "b": parse "@nope()"`, `rule "a": duplicate name`, `rule 4: name is required`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadRuleSet() error = %v, want it to contain %s", err, want)
+		}
+	}
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Errorf("LoadRuleSet() error = %v, want a *ParseError", err)
+	}
+
+	extreme, err := LoadRuleSet([]byte(`{"rules": [
+		{"name": "low", "priority": -9223372036854775808, "then": "1"},
+		{"name": "high", "priority": 9223372036854775807, "then": "2"}
+	]}`))
+	if err != nil {
+		t.Fatalf("LoadRuleSet() error = %v", err)
+	}
+	if res, err := extreme.Eval(nil, FirstMatch); err != nil || res.Value != int64(2) {
+		t.Errorf("RuleSet.Eval() = %v, %v, want rule high first", res, err)
+	}
+
+	failing, err := LoadRuleSet([]byte(`{"rules": [{"name": "div", "then": "$a / $b"}]}`))
+	if err != nil {
+		t.Fatalf("LoadRuleSet() error = %v", err)
+	}
+	if _, err := failing.Eval(map[string]any{"a": 1, "b": 0}, AllMatches); !errors.Is(err, ErrDivisionByZero) || !strings.HasPrefix(err.Error(), `rule "div": `) {
+		t.Errorf("RuleSet.Eval() error = %v, want division by zero in rule div", err)
+	}
+}
+
+func TestDecisionTable(t *testing.T) {
+	const pricing = `{
+		"name": "discount",
+		"hitPolicy": "first",
+		"inputs": ["$amount", "$tier"],
+		"outputs": ["discount", "label"],
+		"rules": [
+			{"when": ["> 1000", "\"gold\",\"platinum\""], "then": ["0.15", "\"premium\""]},
+			{"when": ["[500..1000]", "not(\"basic\")"], "then": ["0.1", "\"mid\""]},
+			{"when": ["(100..500)", "-"], "then": ["$amount / 100 * 0.01", ""]},
+			{"when": ["-", "-"], "then": ["0", "\"none\""]}
+		]
+	}`
+	dt, err := LoadDecisionTable([]byte(pricing))
+	if err != nil {
+		t.Fatalf("LoadDecisionTable() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		vars      map[string]any
+		wantRule  int
+		wantValue map[string]any
+	}{
+		{name: "test1", vars: map[string]any{"amount": 2000, "tier": "gold"}, wantRule: 0, wantValue: map[string]any{"discount": 0.15, "label": "premium"}},
+		{name: "test2", vars: map[string]any{"amount": 2000, "tier": "silver"}, wantRule: 3, wantValue: map[string]any{"discount": int64(0), "label": "none"}},
+		{name: "test3", vars: map[string]any{"amount": 1000, "tier": "silver"}, wantRule: 1, wantValue: map[string]any{"discount": 0.1, "label": "mid"}},
+		{name: "test4", vars: map[string]any{"amount": 500, "tier": "basic"}, wantRule: 3, wantValue: map[string]any{"discount": int64(0), "label": "none"}},
+		{name: "test5", vars: map[string]any{"amount": 300, "tier": "basic"}, wantRule: 2, wantValue: map[string]any{"discount": 0.03, "label": nil}},
+		{name: "test6", vars: map[string]any{"amount": 100, "tier": "gold"}, wantRule: 3, wantValue: map[string]any{"discount": int64(0), "label": "none"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := dt.Eval(tt.vars)
+			if err != nil {
+				t.Fatalf("DecisionTable.Eval() error = %v", err)
+			}
+			if !reflect.DeepEqual(res.Rules, []int{tt.wantRule}) || !reflect.DeepEqual(res.Outputs[0], tt.wantValue) {
+				t.Errorf("DecisionTable.Eval() = %v %v, want [%d] %v", res.Rules, res.Outputs, tt.wantRule, tt.wantValue)
+			}
+		})
+	}
+
+	const shipping = `$weight,$zone,=> cost,priority
+<= 5,"'EU','US'",5,1
+<= 5,'EU',4,2
+> 5,-,20,1
+`
+	for _, tt := range []struct {
+		name      string
+		policy    HitPolicy
+		vars      map[string]any
+		wantRules []int
+		wantErr   error
+	}{
+		{name: "test7", policy: HitPriority, vars: map[string]any{"weight": 2, "zone": "EU"}, wantRules: []int{1}},
+		{name: "test8", policy: HitCollect, vars: map[string]any{"weight": 2, "zone": "EU"}, wantRules: []int{0, 1}},
+		{name: "test9", policy: HitCollect, vars: map[string]any{"weight": 2, "zone": "ASIA"}, wantRules: nil},
+		{name: "test10", policy: HitUnique, vars: map[string]any{"weight": 2, "zone": "EU"}, wantErr: ErrMultipleMatches},
+		{name: "test11", policy: HitUnique, vars: map[string]any{"weight": 9, "zone": "US"}, wantRules: []int{2}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dt, err := LoadDecisionTableCSV(strings.NewReader(shipping), tt.policy)
+			if err != nil {
+				t.Fatalf("LoadDecisionTableCSV() error = %v", err)
+			}
+			res, err := dt.Eval(tt.vars)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecisionTable.Eval() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(res.Rules, tt.wantRules) {
+				t.Errorf("DecisionTable.Eval() rules = %v, want %v", res.Rules, tt.wantRules)
+			}
+		})
+	}
+
+	var noMatch *NoMatchError
+	strict := &DecisionTable{Inputs: []string{"$x"}, Outputs: []string{"y"}, Rules: []TableRule{{When: []string{"> 1"}, Then: []string{"1"}}}}
+	if err := strict.Parse(); err != nil {
+		t.Fatalf("DecisionTable.Parse() error = %v", err)
+	}
+	if _, err := strict.Eval(map[string]any{"x": 0}); !errors.As(err, &noMatch) {
+		t.Errorf("DecisionTable.Eval() error = %v, want *NoMatchError", err)
+	}
+
+	bad := &DecisionTable{
+		Inputs:  []string{"$x"},
+		Outputs: []string{"y"},
+		Rules: []TableRule{
+			{When: []string{"> "}, Then: []string{"1"}},
+			{When: []string{"[1..2"}, Then: []string{"1"}},
+			{When: []string{"-"}, Then: []string{"@nope()"}},
+			{When: []string{"-", "-"}, Then: []string{"1"}},
+		},
+	}
+	err = bad.Parse()
+	for _, want := range []string{`rule 1, input $x: "> "`, `rule 2, input $x: "[1..2"`, `rule 3, output y`, `rule 4: got 2 tests`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("DecisionTable.Parse() error = %v, want it to contain %s", err, want)
+		}
+	}
+	if _, err := bad.Eval(map[string]any{"x": 1}); err == nil {
+		t.Errorf("DecisionTable.Eval() of a table that failed to parse succeeded")
+	}
+	badInput := &DecisionTable{Inputs: []string{"$x +"}, Outputs: []string{"y"}, Rules: []TableRule{{When: []string{"-"}, Then: []string{"1"}}}}
+	if err := badInput.Parse(); err == nil {
+		t.Fatalf("DecisionTable.Parse() succeeded, want a parse error")
+	}
+	if _, err := badInput.Eval(map[string]any{"x": 1}); err == nil {
+		t.Errorf("DecisionTable.Eval() of a table that failed to parse succeeded")
+	}
+
+	typed := &DecisionTable{Inputs: []string{"$x"}, Outputs: []string{"y"}, Rules: []TableRule{{When: []string{`"a"`}, Then: []string{"1"}}}}
+	if err := typed.Parse(WithSchema(Schema{"x": TypeInt})); !errors.Is(err, ErrArgumentType) {
+		t.Errorf("DecisionTable.Parse() error = %v, want ErrArgumentType", err)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	vars := map[string]any{"price": 2.345, "neg": -2.345, "half": 2.5, "qty": 1234, "zero": 0}
+	tests := []struct {
+		name       string
+		expression string
+		opts       []Option
+		want       string
+	}{
+		{name: "test1", expression: `@round($price, 2)`, want: "2.35"},
+		{name: "test2", expression: `@round($price, 2)`, opts: []Option{WithRounding(RoundHalfEven)}, want: "2.34"},
+		{name: "test3", expression: `@round($half)`, want: "3"},
+		{name: "test4", expression: `@round($half)`, opts: []Option{WithRounding(RoundHalfEven)}, want: "2"},
+		{name: "test5", expression: `@floor($neg, 1)`, want: "-2.4"},
+		{name: "test6", expression: `@ceil($neg, 1)`, want: "-2.3"},
+		{name: "test7", expression: `@truncate($neg, 2)`, want: "-2.34"},
+		{name: "test8", expression: `@floor($price)`, want: "2"},
+		{name: "test9", expression: `@round($qty, -2)`, want: "1200"},
+		{name: "test10", expression: `@roundTo($price, 0.05)`, want: "2.35"},
+		{name: "test11", expression: `@roundTo($qty, 50)`, want: "1250"},
+		{name: "test12", expression: `@roundTo($price, 0.25)`, opts: []Option{WithRounding(RoundFloor)}, want: "2.25"},
+		{name: "test13", expression: `@round($price, 2) * 2`, opts: []Option{Decimal()}, want: "4.7"},
+		{name: "test14", expression: `@round($price * 3, 1)`, opts: []Option{Decimal(), WithRounding(RoundTruncate)}, want: "7"},
+		{name: "test15", expression: `@roundTo($qty, $zero)`, opts: []Option{WithDivisionByZero(PolicyNull)}, want: "<nil>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAndExecute(tt.expression, vars, tt.opts...)
+			if err != nil {
+				t.Fatalf("ParseAndExecute() error = %v", err)
+			}
+			if s := fmt.Sprint(got); s != tt.want {
+				t.Errorf("ParseAndExecute() = %s, want %s", s, tt.want)
+			}
+		})
+	}
+
+	if got, _ := ParseAndExecute(`@round($qty)`, vars); got != int64(1234) {
+		t.Errorf("ParseAndExecute() = %#v, want int64 1234", got)
+	}
+	if _, err := ParseExpression(`@round($price, "x")`); !errors.Is(err, ErrArgumentType) {
+		t.Errorf("ParseExpression() error = %v, want ErrArgumentType", err)
+	}
+	if _, err := ParseAndExecute(`@roundTo($qty, $zero)`, vars); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("ParseAndExecute() error = %v, want ErrDivisionByZero", err)
+	}
+
+	env := NewEnv(WithDecimalsPlace(2))
+	env.SetRounding(RoundHalfEven)
+	if got, err := env.ParseAndExecute(`$price * 1`, vars); err != nil || got != 2.34 {
+		t.Errorf("ParseAndExecute() = %v, %v, want 2.34", got, err)
+	}
+}
+
+func TestArithmeticPolicy(t *testing.T) {
+	vars := map[string]any{"max": int64(math.MaxInt64), "min": int64(math.MinInt64), "zero": 0, "x": 7, "f": 1.5}
+	tests := []struct {
+		name       string
+		expression string
+		opts       []Option
+		want       any
+		wantErr    error
+	}{
+		{name: "test1", expression: `$max + 1`, wantErr: ErrOverflow},
+		{name: "test2", expression: `$min - 1`, wantErr: ErrOverflow},
+		{name: "test3", expression: `$max * 2`, wantErr: ErrOverflow},
+		{name: "test4", expression: `$min / -1`, wantErr: ErrOverflow},
+		{name: "test5", expression: `-$min`, wantErr: ErrOverflow},
+		{name: "test6", expression: `$max + 1`, opts: []Option{WithOverflow(PolicyNull)}, want: nil},
+		{name: "test7", expression: `$max + 1`, opts: []Option{WithOverflow(PolicyWrap)}, want: int64(math.MinInt64)},
+		{name: "test8", expression: `$max * 2`, opts: []Option{WithOverflow(PolicySaturate)}, want: int64(math.MaxInt64)},
+		{name: "test9", expression: `$min * 2`, opts: []Option{WithOverflow(PolicySaturate)}, want: int64(math.MinInt64)},
+		{name: "test10", expression: `$max * 2`, opts: []Option{WithOverflow(PolicyPromote)}, want: decimal.RequireFromString("18446744073709551614")},
+		{name: "test11", expression: `$max - 1 + 1`, want: int64(math.MaxInt64)},
+		{name: "test12", expression: `$x / $zero`, wantErr: ErrDivisionByZero},
+		{name: "test13", expression: `$x % $zero`, wantErr: ErrDivisionByZero},
+		{name: "test14", expression: `$f / $zero`, wantErr: ErrDivisionByZero},
+		{name: "test15", expression: `$x / $zero`, opts: []Option{WithDivisionByZero(PolicyNull)}, want: nil},
+		{name: "test16", expression: `$x / $zero`, opts: []Option{WithDivisionByZero(PolicySaturate)}, want: int64(math.MaxInt64)},
+		{name: "test17", expression: `-$x / $zero`, opts: []Option{WithDivisionByZero(PolicySaturate)}, want: int64(math.MinInt64)},
+		{name: "test18", expression: `$x % $zero`, opts: []Option{WithDivisionByZero(PolicySaturate)}, want: int64(0)},
+		{name: "test19", expression: `$f / $zero`, opts: []Option{WithDivisionByZero(PolicySaturate)}, want: math.Inf(1)},
+		{name: "test20", expression: `$x / $zero`, opts: []Option{Decimal(), WithDivisionByZero(PolicyNull)}, want: nil},
+		{name: "test21", expression: `$x / $zero`, opts: []Option{Decimal()}, wantErr: ErrDivisionByZero},
+		{name: "test22", expression: `@add($max, 1)`, opts: []Option{WithOverflow(PolicySaturate)}, want: int64(math.MaxInt64)},
+		{name: "test23", expression: `@div($x, $zero)`, opts: []Option{WithDivisionByZero(PolicyNull)}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAndExecute(tt.expression, vars, tt.opts...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseAndExecute() error = %v, want %v", err, tt.wantErr)
+			}
+			if d, ok := tt.want.(decimal.Decimal); ok {
+				if g, ok := got.(decimal.Decimal); !ok || !g.Equal(d) {
+					t.Errorf("ParseAndExecute() = %#v, want %s", got, d)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseAndExecute() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		vars       map[string]any
+		want       any
+	}{
+		{name: "test1", expression: `$discount > -5`, vars: map[string]any{"discount": -3}, want: true},
+		{name: "test2", expression: `$flag == true`, vars: map[string]any{"flag": true}, want: true},
+		{name: "test3", expression: `$flag == false`, vars: map[string]any{"flag": true}, want: false},
+		{name: "test4", expression: `-$x`, vars: map[string]any{"x": 4}, want: int64(-4)},
+		{name: "test5", expression: `-$x`, vars: map[string]any{"x": "2.5"}, want: -2.5},
+		{name: "test6", expression: `-(1+2)*2`, want: int64(-6)},
+		{name: "test7", expression: `-2.5*2`, want: -5.0},
+		{name: "test8", expression: `null`, want: nil},
+		{name: "test9", expression: `true`, want: true},
+		{name: "test10", expression: `!false && $x > -1`, vars: map[string]any{"x": 0}, want: true},
+		{name: "test11", expression: `@append($name, "\t\"ok\"")`, vars: map[string]any{"name": "Zoë"}, want: "Zoë\t\"ok\""},
+		{name: "test12", expression: `$größe == 'XL' && $mfr != "moto\u00e9"`, vars: map[string]any{"größe": "XL", "mfr": "x"}, want: true},
+		{name: "test13", expression: "@contains(`C:\\dir`, '\\\\')", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAndExecute(tt.expression, tt.vars)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAndExecute() = %#v, %v, want %#v", got, err, tt.want)
 			}
 		})
 	}
@@ -205,7 +1762,7 @@ func BenchmarkGoParserExecute(b *testing.B) {
 	f := newFunc()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		executeFunctionCall(f, vars)
+		executeFunctionCall(f, MapResolver(vars))
 	}
 	b.StopTimer()
 }
@@ -215,7 +1772,33 @@ func BenchmarkGoParserExecuteParallel(b *testing.B) {
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			executeFunctionCall(f, vars)
+			executeFunctionCall(f, MapResolver(vars))
+		}
+	})
+	b.StopTimer()
+}
+
+var numericVars = map[string]any{"price": 120, "qty": 3}
+
+const numericExpression = `$qty*($price+1)-3 > 100 && $qty%2 == 1`
+
+func BenchmarkGoParserExecuteNumeric(b *testing.B) {
+	f, _ := ParseExpression(numericExpression)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		executeFunctionCall(f, MapResolver(numericVars))
+	}
+	b.StopTimer()
+}
+
+func BenchmarkGoParserExecuteNumericParallel(b *testing.B) {
+	f, _ := ParseExpression(numericExpression)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			executeFunctionCall(f, MapResolver(numericVars))
 		}
 	})
 	b.StopTimer()
@@ -291,3 +1874,28 @@ func TestMain(m *testing.M) {
 	}()
 	m.Run()
 }
+
+func FuzzParseExpression(f *testing.F) {
+	for _, seed := range []string{
+		`@trimInt($stock,"stock:")*100+5`,
+		`$stock*($price+1)-3 > 100 && $stock%2 == 1`,
+		`@regexp($mfr,"^moto") || !@hasPrefix($mfr,"x")`,
+		`$a/0`, "$a &", "@trim(", "$", `"abc`, "\n\t@",
+	} {
+		f.Add(seed)
+	}
+	vars := map[string]any{"stock": 120, "price": 1.5, "mfr": "motorola", "a": "x"}
+	f.Fuzz(func(t *testing.T, input string) {
+		for _, opts := range [][]Option{nil, {Strict()}} {
+			fc, err := ParseExpression(input, opts...)
+			if err != nil {
+				var perr *ParseError
+				if errors.As(err, &perr) {
+					_ = perr.Pretty()
+				}
+				return
+			}
+			_, _ = fc.ExecuteE(vars)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-parser/parser/internal/parser"
+)
+
+// resolvePath follows path from v through maps, slices, arrays, structs and
+// pointers. When an element cannot be resolved it returns its index and
+// false.
+func resolvePath(v any, path []parser.PathElem) (any, int, bool) {
+	for i, elem := range path {
+		next, ok := pathStep(v, elem.Key)
+		if !ok {
+			return nil, i, false
+		}
+		v = next
+	}
+	return v, 0, true
+}
+
+// pathStep returns the element of v at key, a string or an int64
+func pathStep(v any, key any) (any, bool) {
+	// Fast paths for decoded JSON
+	switch c := v.(type) {
+	case map[string]any:
+		s, ok := key.(string)
+		if !ok {
+			return nil, false
+		}
+		val, ok := c[s]
+		return val, ok
+	case []any:
+		i, ok := key.(int64)
+		if !ok || i < 0 || i >= int64(len(c)) {
+			return nil, false
+		}
+		return c[i], true
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+
+	var elem reflect.Value
+	switch rv.Kind() {
+	case reflect.Map:
+		k, ok := mapKey(rv.Type().Key(), key)
+		if !ok {
+			return nil, false
+		}
+		elem = rv.MapIndex(k)
+	case reflect.Slice, reflect.Array:
+		i, ok := key.(int64)
+		if !ok || i < 0 || i >= int64(rv.Len()) {
+			return nil, false
+		}
+		elem = rv.Index(int(i))
+	case reflect.Struct:
+		name, ok := key.(string)
+		if !ok {
+			return nil, false
+		}
+		index, ok := structFields(rv.Type())[name]
+		if !ok {
+			return nil, false
+		}
+		var err error
+		// Fails on a nil embedded pointer
+		if elem, err = rv.FieldByIndexErr(index); err != nil {
+			return nil, false
+		}
+	}
+	if !elem.IsValid() {
+		return nil, false
+	}
+	return elem.Interface(), true
+}
+
+// mapKey converts key to a map key of type t, string keys index maps with
+// string keys and int64 keys maps with integer keys
+func mapKey(t reflect.Type, key any) (reflect.Value, bool) {
+	k := reflect.New(t).Elem()
+	switch key := key.(type) {
+	case string:
+		if t.Kind() != reflect.String {
+			return k, false
+		}
+		k.SetString(key)
+	case int64:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if k.OverflowInt(key) {
+				return k, false
+			}
+			k.SetInt(key)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if key < 0 || k.OverflowUint(uint64(key)) {
+				return k, false
+			}
+			k.SetUint(uint64(key))
+		default:
+			return k, false
+		}
+	default:
+		return k, false
+	}
+	return k, true
+}
+
+// fieldCache holds the field indexes of struct types by name
+var fieldCache sync.Map // reflect.Type -> map[string][]int
+
+// structFields returns the exported fields of t, including promoted ones, by
+// Go name and by json tag name. Go names win over tags.
+func structFields(t reflect.Type) map[string][]int {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+
+	fields := make(map[string][]int)
+	visible := reflect.VisibleFields(t)
+	for _, f := range visible {
+		if f.IsExported() {
+			fields[f.Name] = f.Index
+		}
+	}
+	for _, f := range visible {
+		if !f.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		if _, ok := fields[tag]; !ok {
+			fields[tag] = f.Index
+		}
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
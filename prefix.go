@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-parser/parser/internal/parser"
+)
+
+// Types of the constants of the prefix call form, a constant without type
+// is an int
+const (
+	typeInt   = "int"
+	typeFloat = "float"
+	typeStr   = "str"
+)
+
+// ParseFunctionExpression parses expression, function call format is
+// funcName(arg1,arg2,...). Arguments are nested calls, $variables and
+// constants suffixed with their type: 12, 1.5:float or abc:str. Calls are
+// resolved and checked against the default Env like with ParseExpression.
+func ParseFunctionExpression(expr string) (*FunctionCall, error) {
+	node, err := parsePrefix(expr, 0, len(expr))
+	if err != nil {
+		return nil, err
+	}
+
+	defaultEnv.mu.RLock()
+	defer defaultEnv.mu.RUnlock()
+
+	b := &builder{
+		input: expr,
+		funcs: defaultEnv.funcs,
+		opts:  defaultEnv.opts.apply(nil),
+	}
+	f, err := b.call(node)
+	if err != nil {
+		return nil, err
+	}
+	fold(f)
+	compile(f)
+	return f, nil
+}
+
+// parsePrefix parses input[start:end] written in the prefix call form
+func parsePrefix(input string, start, end int) (parser.Node, error) {
+	// Skip the spaces around the element
+	for start < end && isSpace(input[start]) {
+		start++
+	}
+	for end > start && isSpace(input[end-1]) {
+		end--
+	}
+	expr := input[start:end]
+	loc := parser.Span{Start: start, End: end}
+
+	left := strings.IndexByte(expr, '(')
+	if left == -1 {
+		return prefixOperand(input, loc)
+	}
+	if expr[len(expr)-1] != ')' {
+		return nil, newParseError(input, start, expr, []string{")"}, "unclosed call "+expr)
+	}
+	call := &parser.CallExpr{Name: strings.TrimSpace(expr[:left]), Loc: loc}
+	if call.Name == "" {
+		return nil, newParseError(input, start, expr, []string{"function name"}, "missing function name")
+	}
+
+	// Split the arguments on the commas outside of nested calls
+	argStart, argEnd := start+left+1, end-1
+	if strings.TrimSpace(input[argStart:argEnd]) == "" {
+		return call, nil
+	}
+	level := 0
+	for i := argStart; i <= argEnd; i++ {
+		if i < argEnd {
+			switch input[i] {
+			case '(':
+				level++
+				continue
+			case ')':
+				level--
+				continue
+			case ',':
+				if level > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		arg, err := parsePrefix(input, argStart, i)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		argStart = i + 1
+	}
+	return call, nil
+}
+
+// prefixOperand parses a $variable or a typed constant of the prefix call
+// form, an empty operand is the empty string
+func prefixOperand(input string, loc parser.Span) (parser.Node, error) {
+	operand := input[loc.Start:loc.End]
+	if name, ok := strings.CutPrefix(operand, "$"); ok {
+		return &parser.VariableExpr{Name: name, Loc: loc}, nil
+	}
+	if operand == "" {
+		return &parser.LiteralExpr{Kind: parser.StringLiteral, Value: "", Loc: loc}, nil
+	}
+
+	value, typ := operand, typeInt
+	if i := strings.LastIndexByte(operand, ':'); i != -1 {
+		value, typ = operand[:i], operand[i+1:]
+	}
+	lit := &parser.LiteralExpr{Raw: operand, Loc: loc}
+	var err error
+	switch typ {
+	case typeInt:
+		lit.Kind = parser.IntLiteral
+		lit.Value, err = strconv.ParseInt(value, 10, 64)
+	case typeFloat:
+		lit.Kind = parser.FloatLiteral
+		lit.Value, err = strconv.ParseFloat(value, 64)
+	case typeStr:
+		lit.Kind, lit.Value = parser.StringLiteral, value
+	default:
+		return nil, newParseError(input, loc.Start, operand, nil, "invalid argument: "+operand)
+	}
+	if err != nil {
+		return nil, newParseError(input, loc.Start, operand, nil, "invalid "+typ+" argument: "+operand)
+	}
+	return lit, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,62 @@
+package parser
+
+// Resolver supplies the values of $variables. Lookup is called only for the
+// variables an expression evaluates, when it evaluates them, and may be
+// called more than once for the same name.
+type Resolver interface {
+	// Lookup returns the value of the variable name, without the $ prefix,
+	// and whether it is defined
+	Lookup(name string) (any, bool)
+}
+
+// MapResolver resolves variables to the values of a map, it is what Execute
+// uses for its vars
+type MapResolver map[string]any
+
+func (m MapResolver) Lookup(name string) (any, bool) {
+	val, ok := m[name]
+	return val, ok
+}
+
+// ResolverFunc adapts a function to a Resolver, e.g. to fetch values from a
+// cache on demand
+type ResolverFunc func(name string) (any, bool)
+
+func (f ResolverFunc) Lookup(name string) (any, bool) {
+	return f(name)
+}
+
+// StructResolver resolves variables to the exported fields of a struct, by
+// field name or json tag, the same way paths do. v may be a pointer to a
+// struct or a map with string keys.
+func StructResolver(v any) Resolver {
+	return structResolver{v: v}
+}
+
+type structResolver struct {
+	v any
+}
+
+func (r structResolver) Lookup(name string) (any, bool) {
+	return pathStep(r.v, name)
+}
+
+// ChainResolver resolves a variable with the first resolver that defines it,
+// so later resolvers act as fallbacks
+func ChainResolver(resolvers ...Resolver) Resolver {
+	return chainResolver(resolvers)
+}
+
+type chainResolver []Resolver
+
+func (c chainResolver) Lookup(name string) (any, bool) {
+	for _, r := range c {
+		if r == nil {
+			continue
+		}
+		if val, ok := r.Lookup(name); ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,253 @@
+package parser
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Rule is a named Expression of a RuleSet. A rule fires when its expression
+// yields a value: when If is empty or true, or when it is false and there
+// is an Otherwise.
+type Rule struct {
+	Name       string   `json:"name"`
+	Priority   int      `json:"priority,omitempty"` // Rules with a higher priority are evaluated first
+	Tags       []string `json:"tags,omitempty"`
+	Enabled    *bool    `json:"enabled,omitempty"` // Rules are enabled unless set to false
+	Expression          // If, Then and Otherwise
+}
+
+// IsEnabled reports whether the rule is evaluated
+func (r *Rule) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// hasTag reports whether the rule has one of tags
+func (r *Rule) hasTag(tags []string) bool {
+	for _, tag := range tags {
+		if slices.Contains(r.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Strategy selects how a RuleSet combines the rules that fire
+type Strategy int
+
+const (
+	// FirstMatch stops at the first rule that fires, the result is its value
+	FirstMatch Strategy = iota
+	// AllMatches evaluates every rule, the result is the []any of the values
+	// of the rules that fire
+	AllMatches
+	// Sum adds the values of the rules that fire with the add builtin
+	Sum
+	// Min keeps the smallest value of the rules that fire
+	Min
+	// Max keeps the largest value of the rules that fire
+	Max
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case FirstMatch:
+		return "first-match"
+	case AllMatches:
+		return "all-matches"
+	case Sum:
+		return "sum"
+	case Min:
+		return "min"
+	case Max:
+		return "max"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// Fired is a rule that fired and the value it yielded
+type Fired struct {
+	Rule  string
+	Value any
+}
+
+// Result is the outcome of evaluating a RuleSet
+type Result struct {
+	Value any     // Combined according to the strategy, nil if no rule fired
+	Fired []Fired // Rules that fired, in evaluation order
+}
+
+// RuleSet is a list of rules evaluated together. Rules are evaluated by
+// decreasing priority, rules of the same priority in the order they are
+// listed.
+type RuleSet struct {
+	Rules []*Rule  `json:"rules"`
+	order []*Rule  // Rules sorted by priority
+	opts  *options // Options the rules were parsed with
+}
+
+// LoadRuleSet decodes a rule set from JSON and parses its rules using the
+// default Env
+func LoadRuleSet(data []byte, opts ...Option) (*RuleSet, error) {
+	var rs RuleSet
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, err
+	}
+	if err := rs.Parse(opts...); err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// Parse parses every rule using the default Env
+func (rs *RuleSet) Parse(opts ...Option) error {
+	return rs.ParseWith(defaultEnv, opts...)
+}
+
+// ParseWith parses every rule, disabled ones included, using the functions
+// and options of env. All the problems found are returned joined, each
+// naming its rule.
+func (rs *RuleSet) ParseWith(env *Env, opts ...Option) error {
+	var errs []error
+	names := make(map[string]bool, len(rs.Rules))
+	for i, r := range rs.Rules {
+		switch {
+		case r == nil:
+			errs = append(errs, fmt.Errorf("rule %d: rule is null", i+1))
+			continue
+		case r.Name == "":
+			errs = append(errs, fmt.Errorf("rule %d: name is required", i+1))
+		case names[r.Name]:
+			errs = append(errs, fmt.Errorf("rule %q: duplicate name", r.Name))
+		}
+		names[r.Name] = true
+		if err := r.Expression.ParseWith(env, opts...); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", r.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	rs.order = slices.Clone(rs.Rules)
+	slices.SortStableFunc(rs.order, func(a, b *Rule) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+	env.mu.RLock()
+	rs.opts = env.opts.apply(opts)
+	env.mu.RUnlock()
+	return nil
+}
+
+// Tagged returns a rule set holding the rules of rs that have at least one
+// of tags. It shares the parsed rules of rs.
+func (rs *RuleSet) Tagged(tags ...string) *RuleSet {
+	tagged := &RuleSet{opts: rs.opts}
+	for _, r := range rs.Rules {
+		if r != nil && r.hasTag(tags) {
+			tagged.Rules = append(tagged.Rules, r)
+		}
+	}
+	for _, r := range rs.order {
+		if r.hasTag(tags) {
+			tagged.order = append(tagged.order, r)
+		}
+	}
+	return tagged
+}
+
+// Eval evaluates the enabled rules with vars and combines the values of
+// those that fire according to strategy
+func (rs *RuleSet) Eval(vars map[string]any, strategy Strategy) (*Result, error) {
+	return rs.EvalWith(MapResolver(vars), strategy)
+}
+
+// EvalWith evaluates the rule set like Eval, fetching variables from r
+func (rs *RuleSet) EvalWith(r Resolver, strategy Strategy) (*Result, error) {
+	if rs.opts == nil {
+		return nil, fmt.Errorf("rule set is not parsed")
+	}
+	if strategy < FirstMatch || strategy > Max {
+		return nil, fmt.Errorf("unknown strategy %s", strategy)
+	}
+
+	res := &Result{}
+	for _, rule := range rs.order {
+		if !rule.IsEnabled() {
+			continue
+		}
+		val, err := rule.EvalWith(r)
+		var noMatch *NoMatchError
+		if errors.As(err, &noMatch) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		res.Fired = append(res.Fired, Fired{Rule: rule.Name, Value: val})
+		if strategy == FirstMatch {
+			break
+		}
+	}
+	if len(res.Fired) == 0 {
+		return res, nil
+	}
+
+	switch strategy {
+	case FirstMatch:
+		res.Value = res.Fired[0].Value
+	case AllMatches:
+		values := make([]any, len(res.Fired))
+		for i, f := range res.Fired {
+			values[i] = f.Value
+		}
+		res.Value = values
+	default:
+		var err error
+		if res.Value, err = rs.aggregate(strategy, res.Fired); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// aggregate combines the values of fired rules with the builtins, so that
+// numbers are computed like in expressions
+func (rs *RuleSet) aggregate(strategy Strategy, fired []Fired) (any, error) {
+	acc := fired[0].Value
+	for _, f := range fired[1:] {
+		var (
+			keep any
+			err  error
+		)
+		switch strategy {
+		case Sum:
+			acc, err = optionFuncMap["add"](rs.opts, acc, f.Value)
+		case Min:
+			keep, err = funcMap["lt"](f.Value, acc)
+		case Max:
+			keep, err = funcMap["gt"](f.Value, acc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s of rule %q: %w", strategy, f.Rule, err)
+		}
+		if keep == true {
+			acc = f.Value
+		}
+	}
+	return acc, nil
+}
+
+// Variables returns the sorted names of the variables referenced by the
+// rules. The rule set must have been parsed.
+func (rs *RuleSet) Variables() []string {
+	vars := make(map[string]struct{})
+	for _, r := range rs.order {
+		for _, a := range r.actions() {
+			a.execute.collect(vars, nil)
+		}
+	}
+	return sortedKeys(vars)
+}
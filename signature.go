@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"github.com/go-parser/parser/internal/parser"
+)
+
+// Type is the type of a function parameter or result
+type Type int
+
+const (
+	TypeAny    Type = iota // Any value, not checked
+	TypeInt                // Integer
+	TypeFloat              // Floating point number, integers are accepted
+	TypeNumber             // Integer or floating point number
+	TypeString             // String, numbers and booleans are accepted
+	TypeBool               // Boolean
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeInt:
+		return "int"
+	case TypeFloat:
+		return "float"
+	case TypeNumber:
+		return "number"
+	case TypeString:
+		return "string"
+	case TypeBool:
+		return "bool"
+	}
+	return "any"
+}
+
+// numeric reports whether t is one of the number types
+func (t Type) numeric() bool {
+	return t == TypeInt || t == TypeFloat || t == TypeNumber
+}
+
+// Param is a parameter of a function
+type Param struct {
+	Name string
+	Type Type
+}
+
+// Signature describes the parameters and result of a function. Calls to a
+// function registered with a signature are checked when they are parsed.
+type Signature struct {
+	Params   []Param
+	Optional int  // Number of trailing parameters that may be omitted
+	Variadic bool // The last parameter may be repeated or omitted
+	Returns  Type
+}
+
+// MinArgs returns the fewest arguments the function accepts
+func (s *Signature) MinArgs() int {
+	n := len(s.Params) - s.Optional
+	if s.Variadic {
+		n--
+	}
+	return max(n, 0)
+}
+
+// MaxArgs returns the most arguments the function accepts, -1 if variadic
+func (s *Signature) MaxArgs() int {
+	if s.Variadic {
+		return -1
+	}
+	return len(s.Params)
+}
+
+// param returns the parameter the i-th argument is passed to. A variadic
+// signature without parameters accepts any argument.
+func (s *Signature) param(i int) Param {
+	if len(s.Params) == 0 {
+		return Param{Type: TypeAny}
+	}
+	if i >= len(s.Params) {
+		return s.Params[len(s.Params)-1]
+	}
+	return s.Params[i]
+}
+
+// String renders the signature as (name type, ...) type
+func (s *Signature) String() string {
+	params := make([]string, len(s.Params))
+	for i, p := range s.Params {
+		params[i] = p.Name + " " + p.Type.String()
+		if s.Variadic && i == len(s.Params)-1 {
+			params[i] = p.Name + " ..." + p.Type.String()
+		}
+	}
+	return "(" + strings.Join(params, ", ") + ") " + s.Returns.String()
+}
+
+// arity describes the number of arguments accepted
+func (s *Signature) arity() string {
+	minArgs, maxArgs := s.MinArgs(), s.MaxArgs()
+	switch {
+	case maxArgs < 0:
+		return fmt.Sprintf("at least %d %s", minArgs, plural(minArgs, "argument"))
+	case minArgs == maxArgs:
+		return fmt.Sprintf("%d %s", minArgs, plural(minArgs, "argument"))
+	}
+	return fmt.Sprintf("%d to %d arguments", minArgs, maxArgs)
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return noun
+	}
+	return noun + "s"
+}
+
+// WithSignature attaches a signature to a registered function, calls with
+// the wrong number or types of arguments fail to parse
+func WithSignature(sig Signature) FuncOption {
+	return func(d *funcDef) {
+		d.sig = &sig
+	}
+}
+
+// checkCall checks the arguments of a call against the signature of the
+// function it calls
+func (b *builder) checkCall(node parser.Node, name string, sig *Signature, args []parser.Node) error {
+	if n := len(args); n < sig.MinArgs() || sig.MaxArgs() >= 0 && n > sig.MaxArgs() {
+		return b.fail(node, name, fmt.Errorf("%w: %s takes %s, got %d", ErrArgumentCount, name, sig.arity(), n))
+	}
+	for i, arg := range args {
+		p := sig.param(i)
+		if typ := b.typeOf(arg); !b.accepts(p.Type, typ, arg) {
+			return b.fail(arg, b.source(arg),
+				fmt.Errorf("%w: argument %d (%s) of %s must be %s, got %s", ErrArgumentType, i+1, p.Name, name, p.Type, typ))
+		}
+	}
+	return nil
+}
+
+// accepts reports whether a parameter of type param accepts an argument of
+// type arg. Conversions the builtins perform with cast are allowed: numbers
+// widen to float, scalars format as strings and numeric string literals
+// parse as numbers. With a schema only numbers widen.
+func (b *builder) accepts(param, arg Type, node parser.Node) bool {
+	switch {
+	case param == TypeAny || arg == TypeAny || param == arg:
+		return true
+	case param.numeric() && arg.numeric():
+		return param != TypeInt || arg != TypeFloat
+	case b.opts.schema != nil:
+		return false
+	case param == TypeString:
+		return true
+	case param.numeric() && arg == TypeString:
+		lit, ok := node.(*parser.LiteralExpr)
+		if !ok {
+			return false
+		}
+		var err error
+		if param == TypeInt {
+			_, err = cast.ToInt64E(lit.Value)
+		} else {
+			_, err = cast.ToFloat64E(lit.Value)
+		}
+		return err == nil
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+// Case is a branch of a Switch, Then is evaluated when When is true
+type Case struct {
+	When       string  `json:"when"`
+	Then       string  `json:"then"`
+	whenAction *Action `json:"-"`
+	thenAction *Action `json:"-"`
+}
+
+// Switch is an expression with ordered branches. The Then of the first
+// case whose When is true is evaluated, or Default when none is.
+type Switch struct {
+	Cases         []Case  `json:"cases"`
+	Default       string  `json:"default,omitempty"`
+	defaultAction *Action `json:"-"`
+}
+
+func (s *Switch) String() string {
+	var b strings.Builder
+	for _, c := range s.Cases {
+		fmt.Fprintf(&b, "when: %s then: %s, ", c.When, c.Then)
+	}
+	if s.Default != "" {
+		fmt.Fprintf(&b, "default: %s", s.Default)
+	}
+	return strings.TrimSuffix(b.String(), ", ")
+}
+
+// Parse parses the cases and the default using the default Env
+func (s *Switch) Parse(opts ...Option) error {
+	return s.ParseWith(defaultEnv, opts...)
+}
+
+// ParseWith parses the cases and the default using the functions and
+// options of env. Errors name the case they come from.
+func (s *Switch) ParseWith(env *Env, opts ...Option) error {
+	if len(s.Cases) == 0 && s.Default == "" {
+		return fmt.Errorf("cases or default is required")
+	}
+	for i := range s.Cases {
+		c := &s.Cases[i]
+		if c.When == "" || c.Then == "" {
+			return fmt.Errorf("case %d: when and then are required", i+1)
+		}
+		when, err := parseCondition(env, c.When, opts)
+		if err != nil {
+			return fmt.Errorf("case %d: %w", i+1, err)
+		}
+		then, err := env.ParseExpression(c.Then, opts...)
+		if err != nil {
+			return fmt.Errorf("case %d: %w", i+1, err)
+		}
+		c.whenAction = &Action{Expression: c.When, execute: when}
+		c.thenAction = &Action{Expression: c.Then, execute: then}
+	}
+
+	s.defaultAction = nil
+	if s.Default != "" {
+		execute, err := env.ParseExpression(s.Default, opts...)
+		if err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+		s.defaultAction = &Action{Expression: s.Default, execute: execute}
+	}
+	return nil
+}
+
+// Eval evaluates the switch with vars. A *NoMatchError is returned when no
+// case matches and there is no default.
+func (s *Switch) Eval(vars map[string]any) (any, error) {
+	return s.EvalWith(MapResolver(vars))
+}
+
+// EvalWith evaluates the switch like Eval, fetching variables from r
+func (s *Switch) EvalWith(r Resolver) (any, error) {
+	if r == nil {
+		r = MapResolver(nil)
+	}
+	for _, c := range s.Cases {
+		if c.whenAction == nil {
+			return nil, fmt.Errorf("switch is not parsed")
+		}
+		matched, err := executeFunctionCall(c.whenAction.execute, r)
+		if err != nil {
+			return nil, err
+		}
+		if cast.ToBool(matched) {
+			return executeFunctionCall(c.thenAction.execute, r)
+		}
+	}
+	if s.defaultAction != nil {
+		return executeFunctionCall(s.defaultAction.execute, r)
+	}
+	return nil, &NoMatchError{Cases: len(s.Cases)}
+}
+
+// Variables returns the sorted names of the variables referenced by the
+// cases and the default. The switch must have been parsed.
+func (s *Switch) Variables() []string {
+	vars := make(map[string]struct{})
+	for _, a := range s.actions() {
+		a.execute.collect(vars, nil)
+	}
+	return sortedKeys(vars)
+}
+
+// Functions returns the sorted names of the functions called by the cases
+// and the default. The switch must have been parsed.
+func (s *Switch) Functions() []string {
+	funcs := make(map[string]struct{})
+	for _, a := range s.actions() {
+		a.execute.collect(nil, funcs)
+	}
+	return sortedKeys(funcs)
+}
+
+// actions returns the parsed parts of the switch
+func (s *Switch) actions() []*Action {
+	var actions []*Action
+	for _, c := range s.Cases {
+		if c.whenAction != nil {
+			actions = append(actions, c.whenAction, c.thenAction)
+		}
+	}
+	if s.defaultAction != nil {
+		actions = append(actions, s.defaultAction)
+	}
+	return actions
+}